@@ -17,6 +17,7 @@ type Options struct {
 	binlogOps                   []func(options *query.RedisOptions)
 	dbOps                       []func(options *query.MysqlOptions)
 	exportOps                   []func(options *delay.ExportOptions)
+	delayQueue                  *delay.Queue
 	redis                       redis.UniversalClient
 	cachePrefix                 string
 	operationAllowedToDelete    bool
@@ -61,6 +62,14 @@ func WithExportOps(ops ...func(options *delay.ExportOptions)) func(*Options) {
 	}
 }
 
+func WithDelayQueue(qu *delay.Queue) func(*Options) {
+	return func(options *Options) {
+		if qu != nil {
+			getOptionsOrSetDefault(options).delayQueue = qu
+		}
+	}
+}
+
 func WithRedis(rd redis.UniversalClient) func(*Options) {
 	return func(options *Options) {
 		if rd != nil {
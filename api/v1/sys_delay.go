@@ -57,3 +57,231 @@ func BatchDeleteDelayExportByIds(options ...func(*Options)) gin.HandlerFunc {
 		resp.Success()
 	}
 }
+
+// FindDelayQueue
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description FindDelayQueue
+// @Router /delay/queue/list [GET]
+func FindDelayQueue(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.delayQueue == nil {
+		panic("delayQueue is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FindDelayQueue"))
+		defer span.End()
+		list, err := ops.delayQueue.FindQueue()
+		resp.CheckErr(err)
+		resp.SuccessWithData(list)
+	}
+}
+
+// FindDelayTask
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description FindDelayTask
+// @Param params query req.DelayTask true "params"
+// @Router /delay/queue/task/list [GET]
+func FindDelayTask(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.delayQueue == nil {
+		panic("delayQueue is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FindDelayTask"))
+		defer span.End()
+		var r req.DelayTask
+		req.ShouldBind(c, &r)
+		list, err := ops.delayQueue.FindTask(&r)
+		resp.CheckErr(err)
+		resp.SuccessWithPageData(list, &[]resp.DelayTask{}, r.Page)
+	}
+}
+
+// FindDelayPeriodTask
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description FindDelayPeriodTask
+// @Router /delay/queue/period/list [GET]
+func FindDelayPeriodTask(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.delayQueue == nil {
+		panic("delayQueue is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FindDelayPeriodTask"))
+		defer span.End()
+		list, err := ops.delayQueue.FindPeriodTask()
+		resp.CheckErr(err)
+		resp.SuccessWithData(list)
+	}
+}
+
+// PauseDelayQueue
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description PauseDelayQueue
+// @Param params body req.DelayQueueName true "params"
+// @Router /delay/queue/pause [PATCH]
+func PauseDelayQueue(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.delayQueue == nil {
+		panic("delayQueue is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "PauseDelayQueue"))
+		defer span.End()
+		var r req.DelayQueueName
+		req.ShouldBind(c, &r)
+		err := ops.delayQueue.PauseQueue(r.Queue)
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
+// ResumeDelayQueue
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description ResumeDelayQueue
+// @Param params body req.DelayQueueName true "params"
+// @Router /delay/queue/resume [PATCH]
+func ResumeDelayQueue(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.delayQueue == nil {
+		panic("delayQueue is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "ResumeDelayQueue"))
+		defer span.End()
+		var r req.DelayQueueName
+		req.ShouldBind(c, &r)
+		err := ops.delayQueue.ResumeQueue(r.Queue)
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
+// RunDelayTask
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description RunDelayTask
+// @Param params body req.DelayTaskId true "params"
+// @Router /delay/queue/task/run [PATCH]
+func RunDelayTask(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.delayQueue == nil {
+		panic("delayQueue is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "RunDelayTask"))
+		defer span.End()
+		var r req.DelayTaskId
+		req.ShouldBind(c, &r)
+		err := ops.delayQueue.RunTask(r.Queue, r.Uid)
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
+// DeleteDelayTask
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description DeleteDelayTask
+// @Param params body req.DelayTaskId true "params"
+// @Router /delay/queue/task/delete [DELETE]
+func DeleteDelayTask(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.delayQueue == nil {
+		panic("delayQueue is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "DeleteDelayTask"))
+		defer span.End()
+		var r req.DelayTaskId
+		req.ShouldBind(c, &r)
+		err := ops.delayQueue.DeleteTask(r.Queue, r.Uid)
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
+// GetDelayTaskCallback
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description GetDelayTaskCallback
+// @Param params query req.DelayTaskId true "params"
+// @Router /delay/queue/task/callback [GET]
+func GetDelayTaskCallback(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.delayQueue == nil {
+		panic("delayQueue is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "GetDelayTaskCallback"))
+		defer span.End()
+		var r req.DelayTaskId
+		req.ShouldBind(c, &r)
+		rp, err := ops.delayQueue.GetCallback(r.Uid)
+		resp.CheckErr(err)
+		resp.SuccessWithData(rp)
+	}
+}
+
+// GetDelayTaskResult
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Delay
+// @Description GetDelayTaskResult
+// @Param params query req.DelayTaskId true "params"
+// @Router /delay/queue/task/result [GET]
+func GetDelayTaskResult(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.delayQueue == nil {
+		panic("delayQueue is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "GetDelayTaskResult"))
+		defer span.End()
+		var r req.DelayTaskId
+		req.ShouldBind(c, &r)
+		rp, err := ops.delayQueue.GetResult(r.Uid)
+		resp.CheckErr(err)
+		resp.SuccessWithData(rp)
+	}
+}
@@ -2,6 +2,7 @@ package v1
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/piupuer/go-helper/pkg/constant"
 	"github.com/piupuer/go-helper/pkg/query"
 	"github.com/piupuer/go-helper/pkg/req"
 	"github.com/piupuer/go-helper/pkg/resp"
@@ -33,6 +34,108 @@ func FindFsm(options ...func(*Options)) gin.HandlerFunc {
 	}
 }
 
+// GetFsmGraph
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description GetFsmGraph
+// @Param id path uint true "id"
+// @Param params query req.FsmMachineGraph true "params"
+// @Router /fsm/graph/{id} [GET]
+func GetFsmGraph(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "GetFsmGraph"))
+		defer span.End()
+		var r req.FsmMachineGraph
+		req.ShouldBind(c, &r)
+		r.Id = req.UintId(c)
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		rp, err := q.GetFsmGraph(r)
+		resp.CheckErr(err)
+		resp.SuccessWithData(rp)
+	}
+}
+
+// GetFsmAnalytics
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description GetFsmAnalytics
+// @Param params query req.FsmAnalytics true "params"
+// @Router /fsm/analytics [GET]
+func GetFsmAnalytics(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "GetFsmAnalytics"))
+		defer span.End()
+		var r req.FsmAnalytics
+		req.ShouldBind(c, &r)
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		rp, err := q.GetFsmAnalytics(r)
+		resp.CheckErr(err)
+		resp.SuccessWithData(rp)
+	}
+}
+
+// FindFsmVersion
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description FindFsmVersion
+// @Param id path uint true "id"
+// @Router /fsm/version/list/{id} [GET]
+func FindFsmVersion(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FindFsmVersion"))
+		defer span.End()
+		id := req.UintId(c)
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		list := q.FindFsmVersion(id)
+		resp.SuccessWithData(list)
+	}
+}
+
+// DiffFsmVersion
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description DiffFsmVersion
+// @Param id path uint true "id"
+// @Param params query req.FsmMachineVersionDiff true "params"
+// @Router /fsm/version/diff/{id} [GET]
+func DiffFsmVersion(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "DiffFsmVersion"))
+		defer span.End()
+		var r req.FsmMachineVersionDiff
+		req.ShouldBind(c, &r)
+		r.Id = req.UintId(c)
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		rp, err := q.DiffFsmVersion(r)
+		resp.CheckErr(err)
+		resp.SuccessWithData(rp)
+	}
+}
+
 // FindFsmApprovingLog
 // @Security Bearer
 // @Accept json
@@ -190,13 +293,23 @@ func UpdateFsmLogSubmitterDetail(options ...func(*Options)) gin.HandlerFunc {
 		ops.addCtx(c)
 		q := query.NewMySql(ops.dbOps...)
 		r.Parse()
-		err := q.FsmCheckEditLogDetailPermission(req.FsmCheckEditLogDetailPermission{
+		var oldDetail, newDetail string
+		if ops.getFsmLogSubmitterDetail != nil {
+			// compare with current business detail
+			oldDetail, newDetail = r.Detail(ops.getFsmLogSubmitterDetail(c, r.FsmLogSubmitterDetail))
+		} else {
+			// compare with submitter detail saved by the log
+			oldDetail, newDetail = r.DetailBy(q.GetFsmLogSubmitterDetail(r.FsmLogSubmitterDetail))
+		}
+		err := q.FsmEditLogDetail(req.FsmCheckEditLogDetailPermission{
 			Category:       r.Category,
 			Uuid:           r.Uuid,
 			ApprovalRoleId: u.RoleId,
 			ApprovalUserId: u.Id,
 			Fields:         r.Keys,
 			Approver:       true,
+			Detail:         newDetail,
+			OldDetail:      oldDetail,
 		})
 		resp.CheckErr(err)
 		err = ops.updateFsmLogSubmitterDetail(c, r)
@@ -236,6 +349,68 @@ func FsmApproveLog(options ...func(*Options)) gin.HandlerFunc {
 	}
 }
 
+// FsmBatchApproveLog
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description FsmBatchApproveLog
+// @Param params body req.FsmBatchApproveLog true "params"
+// @Router /fsm/log/approve/batch [PATCH]
+func FsmBatchApproveLog(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.getCurrentUser == nil {
+		panic("getCurrentUser is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FsmBatchApproveLog"))
+		defer span.End()
+		var r req.FsmBatchApproveLog
+		req.ShouldBind(c, &r)
+		u := ops.getCurrentUser(c)
+		r.ApprovalRoleId = u.RoleId
+		r.ApprovalUserId = u.Id
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		list, err := q.FsmBatchApproveLog(r)
+		resp.CheckErr(err)
+		resp.SuccessWithData(list)
+	}
+}
+
+// FsmAddSignLog
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description FsmAddSignLog
+// @Param params body req.FsmAddSignLog true "params"
+// @Router /fsm/log/sign [PATCH]
+func FsmAddSignLog(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.getCurrentUser == nil {
+		panic("getCurrentUser is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FsmAddSignLog"))
+		defer span.End()
+		var r req.FsmAddSignLog
+		req.ShouldBind(c, &r)
+		u := ops.getCurrentUser(c)
+		r.ApprovalRoleId = u.RoleId
+		r.ApprovalUserId = u.Id
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		err := q.FsmAddSignLog(r)
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
 // FsmCancelLogByUuids
 // @Security Bearer
 // @Accept json
@@ -343,3 +518,100 @@ func BatchDeleteFsmByIds(options ...func(*Options)) gin.HandlerFunc {
 		resp.Success()
 	}
 }
+
+// FindFsmDelegation
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description FindFsmDelegation
+// @Param params query req.FsmDelegation true "params"
+// @Router /fsm/delegation/list [GET]
+func FindFsmDelegation(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.getCurrentUser == nil {
+		panic("getCurrentUser is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "FindFsmDelegation"))
+		defer span.End()
+		var r req.FsmDelegation
+		req.ShouldBind(c, &r)
+		u := ops.getCurrentUser(c)
+		r.UserId = u.Id
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		list := q.FindFsmDelegation(&r)
+		resp.SuccessWithPageData(list, &[]resp.FsmDelegation{}, r.Page)
+	}
+}
+
+// CreateFsmDelegation
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description CreateFsmDelegation
+// @Param params body req.FsmCreateDelegation true "params"
+// @Router /fsm/delegation/create [POST]
+func CreateFsmDelegation(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.getCurrentUser == nil {
+		panic("getCurrentUser is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "CreateFsmDelegation"))
+		defer span.End()
+		var r req.FsmCreateDelegation
+		req.ShouldBind(c, &r)
+		u := ops.getCurrentUser(c)
+		r.FromUserId = u.Id
+		r.FromRoleId = constant.Zero
+		if uint(r.Role) == constant.One {
+			r.FromRoleId = u.RoleId
+		}
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		err := q.CreateFsmDelegation(r)
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
+
+// BatchDeleteFsmDelegationByIds
+// @Security Bearer
+// @Accept json
+// @Produce json
+// @Success 201 {object} resp.Resp "success"
+// @Tags *Fsm
+// @Description BatchDeleteFsmDelegationByIds
+// @Param ids body req.Ids true "ids"
+// @Router /fsm/delegation/delete/batch [DELETE]
+func BatchDeleteFsmDelegationByIds(options ...func(*Options)) gin.HandlerFunc {
+	ops := ParseOptions(options...)
+	if ops.getCurrentUser == nil {
+		panic("getCurrentUser is empty")
+	}
+	return func(c *gin.Context) {
+		ctx := tracing.RealCtx(c)
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Rest, "BatchDeleteFsmDelegationByIds"))
+		defer span.End()
+		var r req.Ids
+		req.ShouldBind(c, &r)
+		u := ops.getCurrentUser(c)
+		fromUserId := u.Id
+		if u.RoleSort == constant.Zero {
+			// super admin can delete all delegations
+			fromUserId = constant.Zero
+		}
+		ops.addCtx(c)
+		q := query.NewMySql(ops.dbOps...)
+		err := q.DeleteFsmDelegationByIds(r.Uints(), fromUserId)
+		resp.CheckErr(err)
+		resp.Success()
+	}
+}
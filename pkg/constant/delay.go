@@ -5,4 +5,47 @@ const (
 	DelayExportObjPrefix      = "delay/export"
 	DelayExportEndPointSuffix = ".aliyuncs.com"
 	DelayExportObjExpire      = 1
+	DelayCallbackRespMaxSize  = 512 // response snippet bytes of http callback
+	DelayCallbackMaxBackoff   = 60  // max seconds between two http callback retries
+	DelayCallbackStatusExpire = 7   // days of http callback status
+	DelayCallbackHeaderMask   = "******"
+	DelayCronMisfireGrace     = 60 // seconds, the run is missed if it is later than grace
+	DelayResultWriterCtxKey   = "DelayResultWriter"
+)
+
+const (
+	DelayQueueCritical = "critical"
+	DelayQueueDefault  = "default"
+	DelayQueueLow      = "low"
+)
+
+const (
+	DelayTaskStatePending   = "pending"
+	DelayTaskStateActive    = "active"
+	DelayTaskStateScheduled = "scheduled"
+	DelayTaskStateRetry     = "retry"
+	DelayTaskStateArchived  = "archived"
+	DelayTaskStateCompleted = "completed"
+)
+
+const (
+	DelayWorkflowStatePending   = "pending"
+	DelayWorkflowStateRunning   = "running"
+	DelayWorkflowStateSucceeded = "succeeded"
+	DelayWorkflowStateFailed    = "failed"
+	DelayWorkflowStateSkipped   = "skipped"
+)
+
+const (
+	DelayMisfireOnce = "once" // fire once now, skip other missed runs
+	DelayMisfireAll  = "all"  // fire all missed runs one by one
+	DelayMisfireSkip = "skip" // skip missed runs, wait for next run
+)
+
+const (
+	DelayResultStatePending   = "pending"
+	DelayResultStateActive    = "active"
+	DelayResultStateRetrying  = "retrying"
+	DelayResultStateCompleted = "completed"
+	DelayResultStateFailed    = "failed"
 )
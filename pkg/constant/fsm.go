@@ -9,6 +9,67 @@ const (
 	FsmLogStatusApproved              // approved
 	FsmLogStatusRefused               // approval rejection
 	FsmLogStatusCancelled             // approval cancelled
+	FsmLogStatusWithdrawn             // submitter withdrawn before any approver acted
+	FsmLogStatusAddSign               // approver added sign before himself
+)
+
+const (
+	FsmAddSignNone   uint = iota // normal approval step
+	FsmAddSignBefore             // ad-hoc approvers approve before the approver who added sign
+	FsmAddSignAfter              // ad-hoc approvers approve after the approver who added sign
+)
+
+const (
+	FsmTimeoutActionNone     uint = iota // do nothing
+	FsmTimeoutActionEscalate             // escalate to fallback role
+	FsmTimeoutActionApprove              // auto approve
+	FsmTimeoutActionRefuse               // auto refuse
+)
+
+const (
+	FsmLevelDiffAdd    = "add"
+	FsmLevelDiffRemove = "remove"
+	FsmLevelDiffUpdate = "update"
+)
+
+const (
+	FsmDetailDiffAdd    = "add"
+	FsmDetailDiffRemove = "remove"
+	FsmDetailDiffUpdate = "update"
+)
+
+const (
+	FsmTaskRemind  = "fsm.remind"
+	FsmTaskTimeout = "fsm.timeout"
+)
+
+const (
+	FsmAfterCommitCtxKey = "FsmAfterCommit"
+)
+
+const (
+	FsmHookSubmit        = "submit"
+	FsmHookLevelApproved = "level-approved"
+	FsmHookRejected      = "rejected"
+	FsmHookResubmit      = "resubmit"
+	FsmHookEnd           = "end"
+	FsmHookCancel        = "cancel"
+	FsmHookWithdraw      = "withdraw"
+	FsmHookRouteKey      = "fsm.hook"
+)
+
+const (
+	FsmNotifyReach  = "reach"
+	FsmNotifyPass   = "pass"
+	FsmNotifyReject = "reject"
+)
+
+const (
+	FsmNotifyMsgTitle  = "go-helper.fsm.notify.title"
+	FsmNotifyMsgReach  = "go-helper.fsm.notify.reach"
+	FsmNotifyMsgCc     = "go-helper.fsm.notify.cc"
+	FsmNotifyMsgPass   = "go-helper.fsm.notify.pass"
+	FsmNotifyMsgReject = "go-helper.fsm.notify.reject"
 )
 
 const (
@@ -16,6 +77,9 @@ const (
 	FsmMsgEnded           = "go-helper.fsm.msg.ended"
 	FsmMsgConfigChanged   = "go-helper.fsm.msg.config-changed"
 	FsmMsgManualCancel    = "go-helper.fsm.msg.manual-cancel"
+	FsmMsgTimeout         = "go-helper.fsm.msg.timeout"
+	FsmMsgWithdraw        = "go-helper.fsm.msg.withdraw"
+	FsmMsgAddSign         = "go-helper.fsm.msg.add-sign"
 )
 
 const (
@@ -0,0 +1,28 @@
+package constant
+
+const (
+	MqRetryAttemptHeader = "x-retry-attempt" // attempts of failed msg
+	MqRetryQueueSuffix   = ".retry."         // example: q1.retry.1
+	MqParkingQueueSuffix = ".parking"        // msg is parked after max attempts
+	MqRetryMaxBackoff    = 3600000           // max milliseconds of retry delay
+)
+
+const (
+	MqOutboxTbPrefix   = "tb_mq_"
+	MqOutboxLockKey    = "mq.outbox.lock"
+	MqOutboxMaxBackoff = 600 // max seconds between two publish retries of outbox msg
+)
+
+const (
+	MqOutboxStatusPending uint = iota
+	MqOutboxStatusSent
+	MqOutboxStatusFailed // max retry exceeded
+)
+
+const (
+	MqDedupKeyPrefix    = "mq.consume.dedup"
+	MqDedupProcessing   = "processing"
+	MqDedupDone         = "done"
+	MqDedupQueueSuffix  = ".dedup" // ttl queue of in progress msg without retry queue, example: q1.dedup
+	MqDedupRequeueDelay = 1000     // milliseconds before requeue in progress msg without retry queue
+)
@@ -0,0 +1,229 @@
+package delay
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/hibiken/asynq"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/lock"
+	"github.com/piupuer/go-helper/pkg/log"
+	"github.com/pkg/errors"
+	"sync"
+	"time"
+)
+
+// Clock time source of queue, use FakeClock to advance time manually in tests
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// FakeClock time only moves when Advance/Set is called
+type FakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now: now,
+	}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *FakeClock) Set(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = now
+}
+
+// kv storage of period tasks, callbacks and workflows, missing key/field returns redis.Nil
+type kv interface {
+	hGet(key, field string) (string, error)
+	hGetAll(key string) (map[string]string, error)
+	hSet(key string, values map[string]string) error
+	hDel(key string, fields ...string) error
+	hExists(key, field string) (bool, error)
+	get(key string) (string, error)
+	set(key, value string, expiration time.Duration) error
+	setNx(key string, expiration time.Duration) bool
+	del(key string)
+}
+
+// broker enqueue, inspect and process tasks
+type broker interface {
+	enqueue(t *asynq.Task, opts ...asynq.Option) error
+	deleteTask(queue, uid string) error
+	runTask(queue, uid string) error
+	pauseQueue(queue string) error
+	unpauseQueue(queue string) error
+	getQueueInfo(queue string) (*asynq.QueueInfo, error)
+	listTasks(queue, state string, page, size int) ([]*asynq.TaskInfo, error)
+	getTaskInfo(queue, uid string) (*asynq.TaskInfo, error)
+}
+
+type redisKv struct {
+	redis redis.UniversalClient
+}
+
+func (r redisKv) hGet(key, field string) (string, error) {
+	return r.redis.HGet(context.Background(), key, field).Result()
+}
+
+func (r redisKv) hGetAll(key string) (map[string]string, error) {
+	return r.redis.HGetAll(context.Background(), key).Result()
+}
+
+func (r redisKv) hSet(key string, values map[string]string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	return r.redis.HSet(context.Background(), key, values).Err()
+}
+
+func (r redisKv) hDel(key string, fields ...string) error {
+	return r.redis.HDel(context.Background(), key, fields...).Err()
+}
+
+func (r redisKv) hExists(key, field string) (bool, error) {
+	return r.redis.HExists(context.Background(), key, field).Result()
+}
+
+func (r redisKv) get(key string) (string, error) {
+	return r.redis.Get(context.Background(), key).Result()
+}
+
+func (r redisKv) set(key, value string, expiration time.Duration) error {
+	return r.redis.Set(context.Background(), key, value, expiration).Err()
+}
+
+func (r redisKv) setNx(key string, expiration time.Duration) bool {
+	return lock.NxLock{
+		Key:        key,
+		Redis:      r.redis,
+		Expiration: expiration,
+	}.Lock()
+}
+
+func (r redisKv) del(key string) {
+	lock.NxLock{
+		Key:   key,
+		Redis: r.redis,
+	}.Unlock()
+}
+
+type asynqBroker struct {
+	redisOpt  asynq.RedisConnOpt
+	client    *asynq.Client
+	inspector *asynq.Inspector
+}
+
+func (a asynqBroker) enqueue(t *asynq.Task, opts ...asynq.Option) (err error) {
+	_, err = a.client.Enqueue(t, opts...)
+	return
+}
+
+func (a asynqBroker) deleteTask(queue, uid string) error {
+	return a.inspector.DeleteTask(queue, uid)
+}
+
+func (a asynqBroker) runTask(queue, uid string) error {
+	return a.inspector.RunTask(queue, uid)
+}
+
+func (a asynqBroker) pauseQueue(queue string) error {
+	return a.inspector.PauseQueue(queue)
+}
+
+func (a asynqBroker) unpauseQueue(queue string) error {
+	return a.inspector.UnpauseQueue(queue)
+}
+
+func (a asynqBroker) getQueueInfo(queue string) (*asynq.QueueInfo, error) {
+	return a.inspector.GetQueueInfo(queue)
+}
+
+func (a asynqBroker) listTasks(queue, state string, page, size int) (list []*asynq.TaskInfo, err error) {
+	var fun func(string, ...asynq.ListOption) ([]*asynq.TaskInfo, error)
+	switch state {
+	case constant.DelayTaskStatePending:
+		fun = a.inspector.ListPendingTasks
+	case constant.DelayTaskStateActive:
+		fun = a.inspector.ListActiveTasks
+	case constant.DelayTaskStateScheduled:
+		fun = a.inspector.ListScheduledTasks
+	case constant.DelayTaskStateRetry:
+		fun = a.inspector.ListRetryTasks
+	case constant.DelayTaskStateArchived:
+		fun = a.inspector.ListArchivedTasks
+	case constant.DelayTaskStateCompleted:
+		fun = a.inspector.ListCompletedTasks
+	default:
+		err = errors.WithStack(ErrTaskStateInvalid)
+		return
+	}
+	list, err = fun(queue, asynq.Page(page), asynq.PageSize(size))
+	return
+}
+
+func (a asynqBroker) getTaskInfo(queue, uid string) (*asynq.TaskInfo, error) {
+	return a.inspector.GetTaskInfo(queue, uid)
+}
+
+func (a asynqBroker) run(h periodTaskHandler) {
+	ops := h.qu.ops
+	retryDelay := func(n int, e error, t *asynq.Task) time.Duration {
+		if delay := callbackRetryDelay(ops.callbackBackoff, n, e); delay >= 0 {
+			return delay
+		}
+		return asynq.DefaultRetryDelayFunc(n, e, t)
+	}
+	queues := make(map[string]int)
+	for name, priority := range ops.queues {
+		if count, ok := ops.queueConcurrency[name]; ok {
+			// limited queue has its own server, it can not starve other queues
+			a.serve(h, asynq.Config{
+				Concurrency: count,
+				Queues: map[string]int{
+					name: priority,
+				},
+				RetryDelayFunc: retryDelay,
+			})
+			continue
+		}
+		queues[name] = priority
+	}
+	if len(queues) > 0 {
+		a.serve(h, asynq.Config{
+			Concurrency:    ops.concurrency,
+			Queues:         queues,
+			StrictPriority: ops.strictPriority,
+			RetryDelayFunc: retryDelay,
+		})
+	}
+}
+
+func (a asynqBroker) serve(h asynq.Handler, cfg asynq.Config) {
+	srv := asynq.NewServer(a.redisOpt, cfg)
+	go func() {
+		if err := srv.Run(h); err != nil {
+			log.WithError(err).Error("run task handler failed")
+		}
+	}()
+}
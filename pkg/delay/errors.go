@@ -6,13 +6,27 @@ var (
 	ErrDbNil                         = fmt.Errorf("db instance is empty")
 	ErrUuidNil                       = fmt.Errorf("uuid is empty")
 	ErrUuidInvalid                   = fmt.Errorf("uuid is invalid")
+	ErrTaskNotFound                  = fmt.Errorf("task not found")
+	ErrResultWriterNil               = fmt.Errorf("result writer is empty")
 	ErrOssSecretInvalid              = fmt.Errorf("oss id or secret is invalid")
 	ErrOssBucketInvalid              = fmt.Errorf("oss bucket is invalid")
 	ErrOssPutObjectFailed            = fmt.Errorf("oss put object failed")
+	ErrLocalSecretNil                = fmt.Errorf("local storage secret is empty")
 	ErrRedisNil                      = fmt.Errorf("redis is empty")
 	ErrRedisInvalid                  = fmt.Errorf("redis is invalid")
+	ErrTickUnsupported               = fmt.Errorf("tick is only supported by memory backend")
+	ErrQueueInvalid                  = fmt.Errorf("queue is invalid")
+	ErrTaskStateInvalid              = fmt.Errorf("task state is invalid")
 	ErrExprInvalid                   = fmt.Errorf("expr is invalid")
+	ErrTimezoneInvalid               = fmt.Errorf("timezone is invalid")
+	ErrMisfireInvalid                = fmt.Errorf("misfire policy is invalid")
 	ErrSaveCron                      = fmt.Errorf("save cron failed")
+	ErrSaveCallback                  = fmt.Errorf("save callback failed")
+	ErrSaveWorkflow                  = fmt.Errorf("save workflow failed")
+	ErrWorkflowStepInvalid           = fmt.Errorf("workflow step is invalid")
+	ErrWorkflowCycle                 = fmt.Errorf("workflow has cycle")
+	ErrWorkflowExists                = fmt.Errorf("workflow already exists")
+	ErrWorkflowNotFound              = fmt.Errorf("workflow not found")
 	ErrHttpCallbackTimeout           = fmt.Errorf("http callback timeout")
 	ErrHttpCallback                  = fmt.Errorf("http callback err")
 	ErrHttpCallbackInvalidStatusCode = fmt.Errorf("http callback invalid status code")
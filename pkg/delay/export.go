@@ -2,7 +2,6 @@ package delay
 
 import (
 	"fmt"
-	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 	"github.com/golang-module/carbon/v2"
 	"github.com/piupuer/go-helper/pkg/constant"
 	"github.com/piupuer/go-helper/pkg/log"
@@ -12,9 +11,9 @@ import (
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
 	"gorm.io/gorm/schema"
-	"net/http"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type Export struct {
@@ -149,15 +148,8 @@ func (ex Export) End(uid string, args ...string) (err error) {
 	}
 	if filename != "" {
 		// 文件名不为空需要上传文件到oss
-		var bucket *oss.Bucket
-		bucket, err = ex.getBucket()
-		if err != nil {
-			err = errors.WithStack(err)
-			return
-		}
-
 		objName := fmt.Sprintf("%s/%s/%s/%s", ex.ops.objPrefix, carbon.Now().ToDateString(), ex.ops.machineId, filepath.Base(filename))
-		err = bucket.PutObjectFromFile(objName, filename)
+		err = ex.getStorage().Put(ex.ops.ctx, objName, filename)
 		if err != nil {
 			log.WithContext(ex.ops.ctx).Error(errors.Wrap(ErrOssPutObjectFailed, err.Error()))
 			err = errors.WithStack(ErrOssPutObjectFailed)
@@ -222,18 +214,13 @@ func (ex Export) FindHistory(r *req.DelayExportHistory) (rp []resp.DelayExportHi
 		page.GetLimit()
 	}
 	page.CountCache = &countCache
-	var bucket *oss.Bucket
-	bucket, err = ex.getBucket()
-	if err != nil {
-		err = errors.WithStack(err)
-		return
-	}
+	storage := ex.getStorage()
 	for i, item := range list {
 		if item.End == constant.One && item.Url != "" {
 			// get signature url
-			var url string
-			url, err = bucket.SignURL(item.Url, http.MethodGet, ex.ops.expire*60)
-			if err != nil {
+			url, e := storage.SignUrl(ex.ops.ctx, item.Url, time.Duration(ex.ops.expire)*time.Minute)
+			if e != nil {
+				log.WithContext(ex.ops.ctx).WithError(e).Warn("sign url failed")
 				continue
 			}
 			list[i].Url = url
@@ -258,19 +245,12 @@ func (ex Export) DeleteHistoryByIds(ids []uint) (err error) {
 		Find(&list)
 	endObjs := make([]string, 0)
 	for _, item := range list {
-		if item.End == constant.One {
+		if item.End == constant.One && item.Url != "" {
 			endObjs = append(endObjs, item.Url)
 		}
 	}
 	if len(endObjs) > 0 {
-		var bucket *oss.Bucket
-		bucket, err = ex.getBucket()
-		if err != nil {
-			session.Rollback()
-			err = errors.WithStack(err)
-			return
-		}
-		_, err = bucket.DeleteObjects(endObjs)
+		err = ex.getStorage().Delete(ex.ops.ctx, endObjs)
 		if err != nil {
 			session.Rollback()
 			err = errors.WithStack(err)
@@ -288,21 +268,11 @@ func (ex Export) DeleteHistoryByIds(ids []uint) (err error) {
 	return
 }
 
-func (ex Export) getBucket() (bucket *oss.Bucket, err error) {
-	var client *oss.Client
-	client, err = oss.New(ex.ops.endpoint, ex.ops.key, ex.ops.secret)
-	if err != nil {
-		log.WithContext(ex.ops.ctx).Error(errors.Wrap(ErrOssSecretInvalid, err.Error()))
-		err = errors.WithStack(ErrOssSecretInvalid)
-		return
+func (ex Export) getStorage() Storage {
+	if ex.ops.storage != nil {
+		return ex.ops.storage
 	}
-	bucket, err = client.Bucket(ex.ops.bucket)
-	if err != nil {
-		log.WithContext(ex.ops.ctx).Error(errors.Wrap(ErrOssBucketInvalid, err.Error()))
-		err = errors.WithStack(ErrOssBucketInvalid)
-		return
-	}
-	return
+	return NewAliyunStorage(ex.ops.endpoint, ex.ops.key, ex.ops.secret, ex.ops.bucket)
 }
 
 func (ex Export) initSession() *gorm.DB {
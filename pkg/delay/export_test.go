@@ -1,11 +1,13 @@
 package delay
 
 import (
+	"context"
 	"fmt"
 	"github.com/piupuer/go-helper/pkg/req"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/schema"
+	"net/url"
 	"testing"
 	"time"
 )
@@ -38,3 +40,45 @@ func TestNewExport(t *testing.T) {
 
 	fmt.Println(ex.FindHistory(&req.DelayExportHistory{}))
 }
+
+func TestNewExportWithLocalStorage(t *testing.T) {
+	db, _ := gorm.Open(mysql.Open("root:root@tcp(127.0.0.1:4306)/gin_web?charset=utf8mb4&collation=utf8mb4_general_ci&parseTime=True&loc=Local&timeout=10000ms"), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+		NamingStrategy: schema.NamingStrategy{
+			TablePrefix:   "tb_",
+			SingularTable: true,
+		},
+	})
+	ls, err := NewLocalStorage("/tmp/export", "http://127.0.0.1:8080/api/delay/export/file", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex := NewExport(
+		WithExportDbNoTx(db),
+		WithExportStorage(ls),
+		WithExportExpire(30),
+	)
+	ex.Start("uuid2", "export 2", "category 1", "start")
+	ex.End("uuid2", "100%", "/tmp/1.xlsx")
+	r := req.DelayExportHistory{}
+	list, err := ex.FindHistory(&r)
+	fmt.Println(list, err)
+	ids := make([]uint, 0)
+	for _, item := range list {
+		ids = append(ids, item.Id)
+	}
+	fmt.Println(ex.DeleteHistoryByIds(ids))
+}
+
+func TestLocalStorage_SignUrl(t *testing.T) {
+	_, err := NewLocalStorage("/tmp/export", "http://127.0.0.1:8080/api/delay/export/file", "")
+	if err == nil {
+		t.Error("empty secret is accepted")
+	}
+	ls, _ := NewLocalStorage("/tmp/export", "http://127.0.0.1:8080/api/delay/export/file/", "secret")
+	rp, _ := ls.SignUrl(context.Background(), "delay/export/a#1?%.xlsx", time.Minute)
+	u, err := url.Parse(rp)
+	if err != nil || u.Path != "/api/delay/export/file/delay/export/a#1?%.xlsx" {
+		t.Errorf("invalid url %s", rp)
+	}
+}
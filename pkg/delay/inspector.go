@@ -0,0 +1,243 @@
+package delay
+
+import (
+	"github.com/golang-module/carbon/v2"
+	"github.com/hibiken/asynq"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/req"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/piupuer/go-helper/pkg/utils"
+	"github.com/pkg/errors"
+	"sort"
+	"time"
+)
+
+// FindQueue get size and task count of each state of all queues
+func (qu Queue) FindQueue() (rp []resp.DelayQueue, err error) {
+	rp = make([]resp.DelayQueue, 0)
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	names := make([]string, 0)
+	for name := range qu.ops.queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		item := resp.DelayQueue{
+			Queue:    name,
+			Priority: qu.ops.queues[name],
+		}
+		info, e := qu.broker.getQueueInfo(name)
+		if e == nil {
+			item.Size = info.Size
+			item.Pending = info.Pending
+			item.Active = info.Active
+			item.Scheduled = info.Scheduled
+			item.Retry = info.Retry
+			item.Archived = info.Archived
+			item.Completed = info.Completed
+			item.Processed = info.Processed
+			item.Failed = info.Failed
+			item.Paused = info.Paused
+			item.Latency = int64(info.Latency / time.Second)
+		}
+		rp = append(rp, item)
+	}
+	return
+}
+
+// FindTask page tasks of a queue by state
+func (qu Queue) FindTask(r *req.DelayTask) (rp []resp.DelayTask, err error) {
+	rp = make([]resp.DelayTask, 0)
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	queue, err := qu.getQueue(r.Queue)
+	if err != nil {
+		return
+	}
+	var info *asynq.QueueInfo
+	info, err = qu.broker.getQueueInfo(queue)
+	if err != nil {
+		// queue has no task yet
+		err = nil
+		return
+	}
+	page := &r.Page
+	state := r.State
+	switch state {
+	case constant.DelayTaskStatePending, "":
+		state = constant.DelayTaskStatePending
+		page.Total = int64(info.Pending)
+	case constant.DelayTaskStateActive:
+		page.Total = int64(info.Active)
+	case constant.DelayTaskStateScheduled:
+		page.Total = int64(info.Scheduled)
+	case constant.DelayTaskStateRetry:
+		page.Total = int64(info.Retry)
+	case constant.DelayTaskStateArchived:
+		page.Total = int64(info.Archived)
+	case constant.DelayTaskStateCompleted:
+		page.Total = int64(info.Completed)
+	default:
+		err = errors.WithStack(ErrTaskStateInvalid)
+		return
+	}
+	if page.Total == 0 {
+		return
+	}
+	limit, offset := page.GetLimit()
+	var tasks []*asynq.TaskInfo
+	tasks, err = qu.broker.listTasks(queue, state, offset/limit+1, limit)
+	if err != nil {
+		return
+	}
+	for _, item := range tasks {
+		rp = append(rp, resp.DelayTask{
+			Uid:           item.ID,
+			Queue:         item.Queue,
+			Name:          item.Type,
+			Payload:       string(item.Payload),
+			State:         item.State.String(),
+			MaxRetry:      item.MaxRetry,
+			Retried:       item.Retried,
+			LastErr:       item.LastErr,
+			LastFailedAt:  carbon.DateTime{Carbon: carbon.Time2Carbon(item.LastFailedAt)},
+			NextProcessAt: carbon.DateTime{Carbon: carbon.Time2Carbon(item.NextProcessAt)},
+		})
+	}
+	return
+}
+
+// FindPeriodTask get all cron tasks
+func (qu Queue) FindPeriodTask() (rp []resp.DelayPeriodTask, err error) {
+	rp = make([]resp.DelayPeriodTask, 0)
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	var m map[string]string
+	m, err = qu.kv.hGetAll(qu.ops.redisPeriodKey)
+	if err != nil {
+		return
+	}
+	for _, v := range m {
+		var item periodTask
+		utils.Json2Struct(v, &item)
+		queue := item.Queue
+		if queue == "" {
+			queue = qu.ops.name
+		}
+		rp = append(rp, resp.DelayPeriodTask{
+			Uid:       item.Uid,
+			Queue:     queue,
+			Name:      item.Name,
+			Payload:   item.Payload,
+			Expr:      item.Expr,
+			Next:      carbon.DateTime{Carbon: carbon.CreateFromTimestamp(item.Next)},
+			Processed: item.Processed,
+			MaxRetry:  item.MaxRetry,
+			Timeout:   item.Timeout,
+			Timezone:  item.Timezone,
+			Jitter:    item.Jitter,
+			Misfire:   item.Misfire,
+			MaxRuns:   item.MaxRuns,
+			Runs:      item.Runs,
+			Ended:     item.Ended,
+		})
+		if item.EndAt > 0 {
+			rp[len(rp)-1].EndAt = carbon.DateTime{Carbon: carbon.CreateFromTimestamp(item.EndAt)}
+		}
+	}
+	sort.Slice(rp, func(i, j int) bool {
+		return rp[i].Uid < rp[j].Uid
+	})
+	return
+}
+
+// PauseQueue tasks of the queue will not be processed until resume
+func (qu Queue) PauseQueue(name string) (err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	queue, err := qu.getQueue(name)
+	if err != nil {
+		return
+	}
+	err = qu.broker.pauseQueue(queue)
+	return
+}
+
+func (qu Queue) ResumeQueue(name string) (err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	queue, err := qu.getQueue(name)
+	if err != nil {
+		return
+	}
+	err = qu.broker.unpauseQueue(queue)
+	return
+}
+
+// RunTask run scheduled/retry/archived task now
+func (qu Queue) RunTask(name, uid string) (err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	queue, err := qu.getQueue(name)
+	if err != nil {
+		return
+	}
+	err = qu.broker.runTask(queue, uid)
+	return
+}
+
+// DeleteTask delete a task of the queue, cron definition is kept(use Remove to delete cron task)
+func (qu Queue) DeleteTask(name, uid string) (err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	queue, err := qu.getQueue(name)
+	if err != nil {
+		return
+	}
+	err = qu.broker.deleteTask(queue, uid)
+	return
+}
+
+// GetCallback http callback config and last status of a task
+func (qu Queue) GetCallback(uid string) (rp resp.DelayCallback, err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	cb := qu.getCallback(uid)
+	rp.Url = cb.Url
+	// header values are usually auth tokens, never return them
+	rp.Headers = make(map[string]string, len(cb.Headers))
+	for k := range cb.Headers {
+		rp.Headers[k] = constant.DelayCallbackHeaderMask
+	}
+	v, e := qu.kv.get(qu.ops.redisCallbackKey + ".status." + uid)
+	if e == nil {
+		utils.Json2Struct(v, &cb)
+		rp.Status = cb.Status
+		rp.Resp = cb.Resp
+		rp.Err = cb.Err
+		rp.Attempts = cb.Attempts
+		rp.At = carbon.DateTime{Carbon: carbon.CreateFromTimestamp(cb.At)}
+	}
+	if rp.Url == "" {
+		// status of global callback
+		rp.Url = cb.Url
+	}
+	return
+}
@@ -0,0 +1,509 @@
+package delay
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/hibiken/asynq"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/pkg/errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// default timeout of asynq if task has no timeout
+const memoryDefaultTimeout = 30 * time.Minute
+
+type memoryValue struct {
+	value    string
+	expireAt time.Time
+}
+
+// memoryKv in-process kv, key expiration is based on clock
+type memoryKv struct {
+	lock   sync.Mutex
+	clock  Clock
+	hashes map[string]map[string]string
+	values map[string]memoryValue
+}
+
+func newMemoryKv(clock Clock) *memoryKv {
+	return &memoryKv{
+		clock:  clock,
+		hashes: make(map[string]map[string]string),
+		values: make(map[string]memoryValue),
+	}
+}
+
+func (m *memoryKv) hGet(key, field string) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	v, ok := m.hashes[key][field]
+	if !ok {
+		return "", redis.Nil
+	}
+	return v, nil
+}
+
+func (m *memoryKv) hGetAll(key string) (map[string]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := make(map[string]string)
+	for k, v := range m.hashes[key] {
+		res[k] = v
+	}
+	return res, nil
+}
+
+func (m *memoryKv) hSet(key string, values map[string]string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.hashes[key]; !ok {
+		m.hashes[key] = make(map[string]string)
+	}
+	for k, v := range values {
+		m.hashes[key][k] = v
+	}
+	return nil
+}
+
+func (m *memoryKv) hDel(key string, fields ...string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, item := range fields {
+		delete(m.hashes[key], item)
+	}
+	return nil
+}
+
+func (m *memoryKv) hExists(key, field string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	_, ok := m.hashes[key][field]
+	return ok, nil
+}
+
+func (m *memoryKv) get(key string) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	v, ok := m.getValue(key)
+	if !ok {
+		return "", redis.Nil
+	}
+	return v.value, nil
+}
+
+func (m *memoryKv) set(key, value string, expiration time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.setValue(key, value, expiration)
+	return nil
+}
+
+func (m *memoryKv) setNx(key string, expiration time.Duration) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.getValue(key); ok {
+		return false
+	}
+	m.setValue(key, "1", expiration)
+	return true
+}
+
+func (m *memoryKv) del(key string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.values, key)
+}
+
+func (m *memoryKv) getValue(key string) (v memoryValue, ok bool) {
+	v, ok = m.values[key]
+	if ok && !v.expireAt.IsZero() && !m.clock.Now().Before(v.expireAt) {
+		delete(m.values, key)
+		ok = false
+	}
+	return
+}
+
+func (m *memoryKv) setValue(key, value string, expiration time.Duration) {
+	v := memoryValue{
+		value: value,
+	}
+	if expiration > 0 {
+		v.expireAt = m.clock.Now().Add(expiration)
+	}
+	m.values[key] = v
+}
+
+type memoryTask struct {
+	info asynq.TaskInfo
+	seq  int64 // enqueue order of tasks with same process time
+}
+
+type memoryQueue struct {
+	tasks     map[string]*memoryTask
+	paused    bool
+	processed int
+	failed    int
+}
+
+// memoryBroker in-process broker, tasks are processed one by one when Queue.Tick is called
+type memoryBroker struct {
+	lock    sync.Mutex
+	clock   Clock
+	queues  map[string]*memoryQueue
+	seq     int64
+	cleared time.Time // last clear archived time
+}
+
+func newMemoryBroker(clock Clock) *memoryBroker {
+	return &memoryBroker{
+		clock:   clock,
+		queues:  make(map[string]*memoryQueue),
+		cleared: clock.Now(),
+	}
+}
+
+// memoryResultWriter save result to the task like asynq.ResultWriter
+type memoryResultWriter struct {
+	broker *memoryBroker
+	queue  string
+	uid    string
+}
+
+func (w memoryResultWriter) Write(data []byte) (n int, err error) {
+	w.broker.lock.Lock()
+	defer w.broker.lock.Unlock()
+	t, ok := w.broker.getQueue(w.queue).tasks[w.uid]
+	if !ok {
+		err = errors.WithStack(ErrTaskNotFound)
+		return
+	}
+	t.info.Result = append([]byte{}, data...)
+	n = len(data)
+	return
+}
+
+func (m *memoryBroker) getQueue(name string) *memoryQueue {
+	q, ok := m.queues[name]
+	if !ok {
+		q = &memoryQueue{
+			tasks: make(map[string]*memoryTask),
+		}
+		m.queues[name] = q
+	}
+	return q
+}
+
+func (m *memoryBroker) enqueue(t *asynq.Task, opts ...asynq.Option) (err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := m.clock.Now()
+	info := asynq.TaskInfo{
+		Queue:         constant.DelayQueueDefault,
+		Type:          t.Type(),
+		Payload:       t.Payload(),
+		MaxRetry:      25,
+		NextProcessAt: now,
+	}
+	for _, item := range opts {
+		switch item.Type() {
+		case asynq.QueueOpt:
+			info.Queue = item.Value().(string)
+		case asynq.TaskIDOpt:
+			info.ID = item.Value().(string)
+		case asynq.MaxRetryOpt:
+			info.MaxRetry = item.Value().(int)
+		case asynq.TimeoutOpt:
+			info.Timeout = item.Value().(time.Duration)
+		case asynq.RetentionOpt:
+			info.Retention = item.Value().(time.Duration)
+		case asynq.ProcessAtOpt:
+			info.NextProcessAt = item.Value().(time.Time)
+		case asynq.ProcessInOpt:
+			info.NextProcessAt = now.Add(item.Value().(time.Duration))
+		}
+	}
+	m.seq++
+	if info.ID == "" {
+		info.ID = fmt.Sprintf("memory.%d", m.seq)
+	}
+	q := m.getQueue(info.Queue)
+	if _, ok := q.tasks[info.ID]; ok {
+		err = fmt.Errorf("%w", asynq.ErrTaskIDConflict)
+		return
+	}
+	info.State = asynq.TaskStatePending
+	if info.NextProcessAt.After(now) {
+		info.State = asynq.TaskStateScheduled
+	}
+	q.tasks[info.ID] = &memoryTask{
+		info: info,
+		seq:  m.seq,
+	}
+	return
+}
+
+func (m *memoryBroker) deleteTask(queue, uid string) (err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	q := m.getQueue(queue)
+	t, ok := q.tasks[uid]
+	if !ok {
+		err = fmt.Errorf("%w", asynq.ErrTaskNotFound)
+		return
+	}
+	if t.info.State == asynq.TaskStateActive {
+		err = fmt.Errorf("task is active: %s", uid)
+		return
+	}
+	delete(q.tasks, uid)
+	return
+}
+
+func (m *memoryBroker) runTask(queue, uid string) (err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	t, ok := m.getQueue(queue).tasks[uid]
+	if !ok {
+		err = fmt.Errorf("%w", asynq.ErrTaskNotFound)
+		return
+	}
+	switch t.info.State {
+	case asynq.TaskStateScheduled, asynq.TaskStateRetry, asynq.TaskStateArchived:
+		t.info.State = asynq.TaskStatePending
+		t.info.NextProcessAt = m.clock.Now()
+	default:
+		err = fmt.Errorf("task is %s: %s", t.info.State, uid)
+	}
+	return
+}
+
+func (m *memoryBroker) pauseQueue(queue string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.getQueue(queue).paused = true
+	return nil
+}
+
+func (m *memoryBroker) unpauseQueue(queue string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.getQueue(queue).paused = false
+	return nil
+}
+
+func (m *memoryBroker) getQueueInfo(queue string) (*asynq.QueueInfo, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	q := m.getQueue(queue)
+	info := &asynq.QueueInfo{
+		Queue:     queue,
+		Paused:    q.paused,
+		Processed: q.processed,
+		Failed:    q.failed,
+		Timestamp: m.clock.Now(),
+	}
+	for _, t := range q.tasks {
+		info.Size++
+		switch t.info.State {
+		case asynq.TaskStatePending:
+			info.Pending++
+		case asynq.TaskStateActive:
+			info.Active++
+		case asynq.TaskStateScheduled:
+			info.Scheduled++
+		case asynq.TaskStateRetry:
+			info.Retry++
+		case asynq.TaskStateArchived:
+			info.Archived++
+		case asynq.TaskStateCompleted:
+			info.Completed++
+		}
+	}
+	return info, nil
+}
+
+func (m *memoryBroker) listTasks(queue, state string, page, size int) (list []*asynq.TaskInfo, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	switch state {
+	case constant.DelayTaskStatePending, constant.DelayTaskStateActive, constant.DelayTaskStateScheduled,
+		constant.DelayTaskStateRetry, constant.DelayTaskStateArchived, constant.DelayTaskStateCompleted:
+	default:
+		err = errors.WithStack(ErrTaskStateInvalid)
+		return
+	}
+	list = make([]*asynq.TaskInfo, 0)
+	tasks := make([]*memoryTask, 0)
+	for _, t := range m.getQueue(queue).tasks {
+		if t.info.State.String() == state {
+			tasks = append(tasks, t)
+		}
+	}
+	sortMemoryTasks(tasks)
+	if page < 1 {
+		page = 1
+	}
+	for i := (page - 1) * size; i < len(tasks) && i < page*size; i++ {
+		info := tasks[i].info
+		list = append(list, &info)
+	}
+	return
+}
+
+func (m *memoryBroker) getTaskInfo(queue, uid string) (*asynq.TaskInfo, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	t, ok := m.getQueue(queue).tasks[uid]
+	if !ok {
+		return nil, fmt.Errorf("%w", asynq.ErrTaskNotFound)
+	}
+	info := t.info
+	return &info, nil
+}
+
+// process all due tasks, higher priority queue first
+func (m *memoryBroker) process(h periodTaskHandler) {
+	names := make([]string, 0)
+	for name := range h.qu.ops.queues {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		pi, pj := h.qu.ops.queues[names[i]], h.qu.ops.queues[names[j]]
+		if pi != pj {
+			return pi > pj
+		}
+		return names[i] < names[j]
+	})
+	for {
+		t := m.next(names)
+		if t == nil {
+			return
+		}
+		m.processTask(h, t)
+	}
+}
+
+// next due task, it will be marked active
+func (m *memoryBroker) next(names []string) (t *memoryTask) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := m.clock.Now()
+	for _, name := range names {
+		q := m.getQueue(name)
+		// remove expired completed tasks
+		for uid, item := range q.tasks {
+			if item.info.State == asynq.TaskStateCompleted && !now.Before(item.info.CompletedAt.Add(item.info.Retention)) {
+				delete(q.tasks, uid)
+			}
+		}
+		if q.paused {
+			continue
+		}
+		due := make([]*memoryTask, 0)
+		for _, item := range q.tasks {
+			switch item.info.State {
+			case asynq.TaskStatePending, asynq.TaskStateScheduled, asynq.TaskStateRetry:
+				if !item.info.NextProcessAt.After(now) {
+					due = append(due, item)
+				}
+			}
+		}
+		if len(due) > 0 {
+			sortMemoryTasks(due)
+			t = due[0]
+			t.info.State = asynq.TaskStateActive
+			return
+		}
+	}
+	return
+}
+
+func (m *memoryBroker) processTask(h periodTaskHandler, t *memoryTask) {
+	m.lock.Lock()
+	info := t.info
+	m.lock.Unlock()
+	timeout := info.Timeout
+	if timeout <= 0 {
+		timeout = memoryDefaultTimeout
+	}
+	// handler runs in real time, so task timeout is not controlled by FakeClock
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	task := Task{
+		Name:    info.Type,
+		Uid:     info.ID,
+		Payload: string(info.Payload),
+		Queue:   info.Queue,
+	}
+	w := memoryResultWriter{
+		broker: m,
+		queue:  info.Queue,
+		uid:    info.ID,
+	}
+	err := h.process(ctx, task, w, info.Retried, info.MaxRetry)
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	q := m.getQueue(info.Queue)
+	if _, ok := q.tasks[info.ID]; !ok {
+		// removed by handler
+		return
+	}
+	now := m.clock.Now()
+	q.processed++
+	if err == nil {
+		if t.info.Retention <= 0 {
+			delete(q.tasks, info.ID)
+			return
+		}
+		t.info.State = asynq.TaskStateCompleted
+		t.info.CompletedAt = now
+		return
+	}
+	q.failed++
+	t.info.LastErr = err.Error()
+	t.info.LastFailedAt = now
+	if t.info.Retried >= t.info.MaxRetry {
+		t.info.State = asynq.TaskStateArchived
+		return
+	}
+	t.info.Retried++
+	t.info.State = asynq.TaskStateRetry
+	delay := callbackRetryDelay(h.qu.ops.callbackBackoff, t.info.Retried, err)
+	if delay < 0 {
+		delay = memoryRetryDelay(t.info.Retried)
+	}
+	t.info.NextProcessAt = now.Add(delay)
+}
+
+// clearDue check clear archived interval is reached
+func (m *memoryBroker) clearDue(second int) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := m.clock.Now()
+	if now.Sub(m.cleared) < time.Duration(second)*time.Second {
+		return false
+	}
+	m.cleared = now
+	return true
+}
+
+// like asynq.DefaultRetryDelayFunc without random part, keep tests deterministic
+func memoryRetryDelay(n int) time.Duration {
+	return time.Duration(n*n*n*n+15) * time.Second
+}
+
+func sortMemoryTasks(tasks []*memoryTask) {
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].info.NextProcessAt.Equal(tasks[j].info.NextProcessAt) {
+			return tasks[i].info.NextProcessAt.Before(tasks[j].info.NextProcessAt)
+		}
+		return tasks[i].seq < tasks[j].seq
+	})
+}
@@ -21,6 +21,8 @@ type ExportOptions struct {
 	endpoint  string
 	bucket    string
 	expire    int64
+	// aliyun oss(key/secret/endpoint/bucket) will be used if it empty
+	storage Storage
 }
 
 func WithExportCtx(ctx context.Context) func(*ExportOptions) {
@@ -82,6 +84,14 @@ func WithExportBucket(bucket string) func(*ExportOptions) {
 	}
 }
 
+func WithExportStorage(storage Storage) func(*ExportOptions) {
+	return func(options *ExportOptions) {
+		if !utils.InterfaceIsNil(storage) {
+			getExportOptionsOrSetDefault(options).storage = storage
+		}
+	}
+}
+
 func WithExportExpire(min int64) func(*ExportOptions) {
 	return func(options *ExportOptions) {
 		if min > 0 {
@@ -121,7 +131,25 @@ type QueueOptions struct {
 	handler         func(ctx context.Context, t Task) error
 	callback        string
 	callbackTimeout int
-	clearArchived   int
+	// first retry delay seconds of failed callback(doubled each retry), retry times is max retry of the task
+	callbackBackoff int
+	callbackHeaders map[string]string
+	// sign callback request like middleware.Sign
+	callbackAppId    string
+	callbackSecret   string
+	redisCallbackKey string
+	redisWorkflowKey string
+	// seconds to keep ended workflow state
+	workflowRetention int
+	clearArchived     int
+	// queue name => priority, tasks without queue option will be sent to name
+	queues           map[string]int
+	strictPriority   bool
+	concurrency      int
+	queueConcurrency map[string]int
+	// in-process backend without redis, tasks are lost after restart
+	memory bool
+	clock  Clock
 }
 
 func WithQueueName(s string) func(*QueueOptions) {
@@ -178,6 +206,50 @@ func WithQueueCallbackTimeout(second int) func(*QueueOptions) {
 	}
 }
 
+func WithQueueCallbackBackoff(second int) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if second > 0 {
+			getQueueOptionsOrSetDefault(options).callbackBackoff = second
+		}
+	}
+}
+
+func WithQueueCallbackHeader(key, val string) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if key != "" {
+			getQueueOptionsOrSetDefault(options).callbackHeaders[key] = val
+		}
+	}
+}
+
+// WithQueueCallbackSign sign callback request, the receiver can verify it by middleware.Sign
+func WithQueueCallbackSign(appId, secret string) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		getQueueOptionsOrSetDefault(options).callbackAppId = appId
+		getQueueOptionsOrSetDefault(options).callbackSecret = secret
+	}
+}
+
+func WithQueueRedisCallbackKey(s string) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		getQueueOptionsOrSetDefault(options).redisCallbackKey = s
+	}
+}
+
+func WithQueueRedisWorkflowKey(s string) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		getQueueOptionsOrSetDefault(options).redisWorkflowKey = s
+	}
+}
+
+func WithQueueWorkflowRetention(second int) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if second > 0 {
+			getQueueOptionsOrSetDefault(options).workflowRetention = second
+		}
+	}
+}
+
 func WithQueueClearArchived(second int) func(*QueueOptions) {
 	return func(options *QueueOptions) {
 		if second > 0 {
@@ -186,16 +258,75 @@ func WithQueueClearArchived(second int) func(*QueueOptions) {
 	}
 }
 
+// WithQueuePriority add a queue with priority(weight), example: critical=6, default=3, low=1
+func WithQueuePriority(name string, priority int) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if name != "" && priority > 0 {
+			getQueueOptionsOrSetDefault(options).queues[name] = priority
+		}
+	}
+}
+
+// WithQueueStrictPriority tasks in lower priority queue will be processed only if higher priority queues are empty
+func WithQueueStrictPriority(flag bool) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		getQueueOptionsOrSetDefault(options).strictPriority = flag
+	}
+}
+
+func WithQueueConcurrency(count int) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if count > 0 {
+			getQueueOptionsOrSetDefault(options).concurrency = count
+		}
+	}
+}
+
+// WithQueueConcurrencyLimit max concurrency of a queue, the queue will be processed by a separate server
+func WithQueueConcurrencyLimit(name string, count int) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if name != "" && count > 0 {
+			getQueueOptionsOrSetDefault(options).queueConcurrency[name] = count
+		}
+	}
+}
+
+// WithQueueMemory use in-process backend instead of redis, it is useful for tests and single process
+func WithQueueMemory(flag bool) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		getQueueOptionsOrSetDefault(options).memory = flag
+	}
+}
+
+// WithQueueClock time source of memory backend, with FakeClock tasks only run when Queue.Tick is called.
+// Scheduling and retry delays follow the clock, but task timeout(WithQueueTaskTimeout) is still real time
+func WithQueueClock(clock Clock) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if clock != nil {
+			getQueueOptionsOrSetDefault(options).clock = clock
+		}
+	}
+}
+
 func getQueueOptionsOrSetDefault(options *QueueOptions) *QueueOptions {
 	if options == nil {
 		return &QueueOptions{
-			name:            "delay",
-			redisUri:        "redis://127.0.0.1:6379/0",
-			redisPeriodKey:  "delay.queue.period",
-			retention:       60,
-			maxRetry:        3,
-			callbackTimeout: 0,
-			clearArchived:   300,
+			name:              "delay",
+			redisUri:          "redis://127.0.0.1:6379/0",
+			redisPeriodKey:    "delay.queue.period",
+			retention:         60,
+			maxRetry:          3,
+			callbackTimeout:   0,
+			callbackBackoff:   1,
+			callbackHeaders:   make(map[string]string),
+			redisCallbackKey:  "delay.queue.callback",
+			redisWorkflowKey:  "delay.queue.workflow",
+			workflowRetention: 86400,
+			clearArchived:     300,
+			queues:            make(map[string]int),
+			concurrency:       10,
+			queueConcurrency:  make(map[string]int),
+			clock:             realClock{},
 		}
 	}
 	return options
@@ -206,12 +337,21 @@ type QueueTaskOptions struct {
 	name      string
 	payload   string
 	expr      string         // only period task
+	timezone  string         // only period task
+	jitter    int            // only period task
+	misfire   string         // only period task
+	endAt     *time.Time     // only period task
+	maxRuns   int64          // only period task
 	in        *time.Duration // only once task
 	at        *time.Time     // only once task
 	now       bool           // only once task
 	retention int            // only once task
 	maxRetry  int
 	timeout   int
+	queue     string
+	// http callback of the task(global callback will be ignored)
+	callback        string
+	callbackHeaders map[string]string
 }
 
 func WithQueueTaskUuid(s string) func(*QueueTaskOptions) {
@@ -238,6 +378,43 @@ func WithQueueTaskExpr(s string) func(*QueueTaskOptions) {
 	}
 }
 
+// WithQueueTaskTimezone location name of expr, example: Asia/Shanghai(server local time if it empty)
+func WithQueueTaskTimezone(s string) func(*QueueTaskOptions) {
+	return func(options *QueueTaskOptions) {
+		getQueueTaskOptionsOrSetDefault(options).timezone = s
+	}
+}
+
+// WithQueueTaskJitter random delay seconds of each run, avoid many tasks run at the same time
+func WithQueueTaskJitter(second int) func(*QueueTaskOptions) {
+	return func(options *QueueTaskOptions) {
+		if second > 0 {
+			getQueueTaskOptionsOrSetDefault(options).jitter = second
+		}
+	}
+}
+
+// WithQueueTaskMisfire policy of missed runs when scanner was down: constant.DelayMisfireOnce/DelayMisfireAll/DelayMisfireSkip
+func WithQueueTaskMisfire(s string) func(*QueueTaskOptions) {
+	return func(options *QueueTaskOptions) {
+		getQueueTaskOptionsOrSetDefault(options).misfire = s
+	}
+}
+
+func WithQueueTaskEndAt(end time.Time) func(*QueueTaskOptions) {
+	return func(options *QueueTaskOptions) {
+		getQueueTaskOptionsOrSetDefault(options).endAt = &end
+	}
+}
+
+func WithQueueTaskMaxRuns(count int64) func(*QueueTaskOptions) {
+	return func(options *QueueTaskOptions) {
+		if count > 0 {
+			getQueueTaskOptionsOrSetDefault(options).maxRuns = count
+		}
+	}
+}
+
 func WithQueueTaskIn(in time.Duration) func(*QueueTaskOptions) {
 	return func(options *QueueTaskOptions) {
 		getQueueTaskOptionsOrSetDefault(options).in = &in
@@ -278,11 +455,34 @@ func WithQueueTaskTimeout(second int) func(*QueueTaskOptions) {
 	}
 }
 
+// WithQueueTaskQueue queue of the task, it must be added by WithQueuePriority/WithQueueConcurrencyLimit
+func WithQueueTaskQueue(name string) func(*QueueTaskOptions) {
+	return func(options *QueueTaskOptions) {
+		getQueueTaskOptionsOrSetDefault(options).queue = name
+	}
+}
+
+func WithQueueTaskCallback(s string) func(*QueueTaskOptions) {
+	return func(options *QueueTaskOptions) {
+		getQueueTaskOptionsOrSetDefault(options).callback = s
+	}
+}
+
+func WithQueueTaskCallbackHeader(key, val string) func(*QueueTaskOptions) {
+	return func(options *QueueTaskOptions) {
+		if key != "" {
+			getQueueTaskOptionsOrSetDefault(options).callbackHeaders[key] = val
+		}
+	}
+}
+
 func getQueueTaskOptionsOrSetDefault(options *QueueTaskOptions) *QueueTaskOptions {
 	if options == nil {
 		return &QueueTaskOptions{
-			name:    "delay.queue.task",
-			timeout: 0,
+			name:            "delay.queue.task",
+			timeout:         0,
+			misfire:         constant.DelayMisfireAll,
+			callbackHeaders: make(map[string]string),
 		}
 	}
 	return options
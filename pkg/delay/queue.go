@@ -7,12 +7,15 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/golang-module/carbon/v2"
 	"github.com/hibiken/asynq"
-	"github.com/piupuer/go-helper/pkg/lock"
+	"github.com/piupuer/go-helper/pkg/constant"
 	"github.com/piupuer/go-helper/pkg/log"
+	"github.com/piupuer/go-helper/pkg/middleware"
 	"github.com/piupuer/go-helper/pkg/tracing"
 	"github.com/piupuer/go-helper/pkg/utils"
 	"github.com/pkg/errors"
 	"github.com/robfig/cron/v3"
+	"io"
+	"math/rand"
 	"net"
 	"net/http"
 	"strings"
@@ -20,13 +23,11 @@ import (
 )
 
 type Queue struct {
-	ops       QueueOptions
-	redis     redis.UniversalClient
-	redisOpt  asynq.RedisConnOpt
-	nxLock    lock.NxLock
-	client    *asynq.Client
-	inspector *asynq.Inspector
-	Error     error
+	ops    QueueOptions
+	kv     kv
+	broker broker
+	clock  Clock
+	Error  error
 }
 
 type periodTask struct {
@@ -38,29 +39,63 @@ type periodTask struct {
 	Processed int64  `json:"processed"` // run times
 	MaxRetry  int    `json:"maxRetry"`
 	Timeout   int    `json:"timeout"`
+	Queue     string `json:"queue"`
+	Timezone  string `json:"timezone"` // location name of expr, example: Asia/Shanghai
+	Jitter    int    `json:"jitter"`   // max random delay seconds
+	Misfire   string `json:"misfire"`  // missed runs policy: once/all/skip
+	EndAt     int64  `json:"endAt"`    // no more runs after end unix timestamp
+	MaxRuns   int64  `json:"maxRuns"`
+	Runs      int64  `json:"runs"` // scheduled times
+	Ended     bool   `json:"ended"`
 }
 
 type periodTaskHandler struct {
 	qu Queue
 }
 
+// http callback config and last status of a task
+type callback struct {
+	Url      string            `json:"url"`
+	Headers  map[string]string `json:"headers"`
+	Status   int               `json:"status"`   // last response status code
+	Resp     string            `json:"resp"`     // last response snippet
+	Err      string            `json:"err"`      // last error
+	Attempts int               `json:"attempts"` // attempts of last callback
+	At       int64             `json:"at"`       // last callback unix timestamp
+}
+
 type Task struct {
 	Name    string `json:"name"`
 	Uid     string `json:"uid"`
 	Payload string `json:"payload"`
+	Queue   string `json:"queue"`
 }
 
-func (p periodTaskHandler) ProcessTask(ctx context.Context, t *asynq.Task) (err error) {
-	ctx = tracing.NewId(ctx)
+func (p periodTaskHandler) ProcessTask(ctx context.Context, t *asynq.Task) error {
 	task := Task{
 		Name:    t.Type(),
 		Uid:     t.ResultWriter().TaskID(),
 		Payload: string(t.Payload()),
 	}
-	if p.qu.ops.handler != nil {
+	task.Queue, _ = asynq.GetQueueName(ctx)
+	retried, _ := asynq.GetRetryCount(ctx)
+	maxRetry, _ := asynq.GetMaxRetry(ctx)
+	return p.process(ctx, task, t.ResultWriter(), retried, maxRetry)
+}
+
+// process task by callback or handler, the task will be retried if it failed and retried < maxRetry
+func (p periodTaskHandler) process(ctx context.Context, task Task, w io.Writer, retried, maxRetry int) (err error) {
+	ctx = tracing.NewId(ctx)
+	// handler can save result by WriteResult
+	ctx = context.WithValue(ctx, constant.DelayResultWriterCtxKey, w)
+	cb := p.qu.getCallback(task.Uid)
+	if cb.Url != "" {
+		err = p.httpCallback(ctx, task, cb, retried)
+	} else if p.qu.ops.handler != nil {
 		err = p.qu.ops.handler(ctx, task)
 	} else if p.qu.ops.callback != "" {
-		err = p.httpCallback(ctx, task)
+		cb.Url = p.qu.ops.callback
+		err = p.httpCallback(ctx, task, cb, retried)
 	} else {
 		log.
 			WithContext(ctx).
@@ -71,17 +106,84 @@ func (p periodTaskHandler) ProcessTask(ctx context.Context, t *asynq.Task) (err
 	}
 	// save processed count
 	p.qu.processed(task.Uid)
+	// start next steps if it is a workflow step
+	p.qu.workflowProcessed(ctx, task, err, retried < maxRetry)
+	return
+}
+
+// send http callback once, failed callback is retried by task retry(see callbackRetryDelay)
+func (p periodTaskHandler) httpCallback(ctx context.Context, task Task, cb callback, retried int) (err error) {
+	ops := p.qu.ops
+	headers := make(map[string]string)
+	for k, v := range ops.callbackHeaders {
+		headers[k] = v
+	}
+	for k, v := range cb.Headers {
+		headers[k] = v
+	}
+	body := utils.Struct2Json(task)
+	cb.Attempts = retried + 1
+	cb.Status, cb.Resp, err = p.doHttpCallback(ctx, cb.Url, headers, body)
+	cb.Err = ""
+	if err != nil {
+		cb.Err = err.Error()
+	}
+	cb.At = p.qu.now().Unix()
+	p.qu.saveCallbackStatus(task.Uid, cb)
+	if err == nil && cb.Resp != "" {
+		// response is the result of task
+		WriteResult(ctx, []byte(cb.Resp))
+	}
+	if err == nil && strings.HasSuffix(task.Name, ".once") {
+		// once task callback config is useless after success
+		p.qu.kv.hDel(ops.redisCallbackKey, task.Uid)
+	}
 	return
 }
 
-func (p periodTaskHandler) httpCallback(ctx context.Context, task Task) (err error) {
+// exponential backoff of failed http callback, other errors use default delay of asynq
+func callbackRetryDelay(backoff, n int, e error) time.Duration {
+	if !errors.Is(e, ErrHttpCallback) && !errors.Is(e, ErrHttpCallbackTimeout) && !errors.Is(e, ErrHttpCallbackInvalidStatusCode) {
+		return -1
+	}
+	maxDelay := constant.DelayCallbackMaxBackoff * time.Second
+	delay := time.Duration(backoff) * time.Second
+	for i := 1; i < n && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+func (p periodTaskHandler) doHttpCallback(ctx context.Context, target string, headers map[string]string, body string) (status int, snippet string, err error) {
 	client := &http.Client{
 		Timeout: time.Duration(p.qu.ops.callbackTimeout) * time.Second,
 	}
-	body := utils.Struct2Json(task)
 	var r *http.Request
-	r, _ = http.NewRequest(http.MethodPost, p.qu.ops.callback, bytes.NewReader([]byte(body)))
+	r, err = http.NewRequest(http.MethodPost, target, bytes.NewReader([]byte(body)))
+	if err != nil {
+		log.
+			WithContext(ctx).
+			WithFields(map[string]interface{}{
+				"Task": body,
+			}).
+			WithError(err).
+			Error(ErrHttpCallback)
+		err = ErrHttpCallback
+		return
+	}
 	r.Header.Add("Content-Type", gin.MIMEJSON)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	if p.qu.ops.callbackAppId != "" && p.qu.ops.callbackSecret != "" {
+		r.Header.Set(
+			constant.MiddlewareSignTokenHeaderKey,
+			middleware.GenSignToken(p.qu.ops.callbackAppId, p.qu.ops.callbackSecret, r.Method, r.URL.RequestURI(), body),
+		)
+	}
 	var res *http.Response
 	res, err = client.Do(r)
 	if e, ok := err.(net.Error); ok && e.Timeout() {
@@ -107,12 +209,16 @@ func (p periodTaskHandler) httpCallback(ctx context.Context, task Task) (err err
 		return
 	}
 	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
+	status = res.StatusCode
+	b, _ := io.ReadAll(io.LimitReader(res.Body, constant.DelayCallbackRespMaxSize))
+	snippet = string(b)
+	if status < http.StatusOK || status >= http.StatusMultipleChoices {
 		log.
 			WithContext(ctx).
 			WithFields(map[string]interface{}{
 				"Task":       body,
-				"StatusCode": res.StatusCode,
+				"StatusCode": status,
+				"Resp":       snippet,
 			}).
 			Error(ErrHttpCallbackInvalidStatusCode)
 		err = ErrHttpCallbackInvalidStatusCode
@@ -121,12 +227,37 @@ func (p periodTaskHandler) httpCallback(ctx context.Context, task Task) (err err
 }
 
 // NewQueue delay queue implemented by asynq: https://github.com/hibiken/asynq
+// or in-process memory backend(WithQueueMemory) which needs no redis
 func NewQueue(options ...func(*QueueOptions)) (qu *Queue) {
 	ops := getQueueOptionsOrSetDefault(nil)
 	for _, f := range options {
 		f(ops)
 	}
 	qu = &Queue{}
+	for name := range ops.queueConcurrency {
+		if _, ok := ops.queues[name]; !ok {
+			ops.queues[name] = 1
+		}
+	}
+	if _, ok := ops.queues[ops.name]; !ok {
+		ops.queues[ops.name] = 1
+	}
+	qu.ops = *ops
+	qu.clock = ops.clock
+	if ops.memory {
+		qu.kv = newMemoryKv(ops.clock)
+		qu.broker = newMemoryBroker(ops.clock)
+		if _, ok := ops.clock.(*FakeClock); !ok {
+			// time goes by itself, tick every second
+			go func() {
+				for {
+					time.Sleep(time.Second)
+					qu.Tick()
+				}
+			}()
+		}
+		return
+	}
 	if ops.redisUri == "" {
 		qu.Error = errors.WithStack(ErrRedisNil)
 		return
@@ -137,37 +268,19 @@ func NewQueue(options ...func(*QueueOptions)) (qu *Queue) {
 		return
 	}
 	rd := rs.MakeRedisClient().(redis.UniversalClient)
-	client := asynq.NewClient(rs)
-	inspector := asynq.NewInspector(rs)
-	// initialize redis lock
-	nxLock := lock.NxLock{
-		Key:        ops.redisPeriodKey + ".lock",
-		Redis:      rd,
-		Expiration: 10 * time.Second,
-	}
-	// initialize server
-	srv := asynq.NewServer(
-		rs,
-		asynq.Config{
-			Concurrency: 10,
-			Queues: map[string]int{
-				ops.name: 10,
-			},
-		},
-	)
-	go func() {
-		var h periodTaskHandler
-		h.qu = *qu
-		if e := srv.Run(h); e != nil {
-			log.WithError(err).Error("run task handler failed")
-		}
-	}()
-	qu.ops = *ops
-	qu.redis = rd
-	qu.redisOpt = rs
-	qu.nxLock = nxLock
-	qu.client = client
-	qu.inspector = inspector
+	b := asynqBroker{
+		redisOpt:  rs,
+		client:    asynq.NewClient(rs),
+		inspector: asynq.NewInspector(rs),
+	}
+	qu.kv = redisKv{
+		redis: rd,
+	}
+	qu.broker = b
+	// initialize servers
+	b.run(periodTaskHandler{
+		qu: *qu,
+	})
 	// initialize scanner
 	go func() {
 		for {
@@ -187,6 +300,49 @@ func NewQueue(options ...func(*QueueOptions)) (qu *Queue) {
 	return
 }
 
+// Tick scan cron tasks and process all due tasks once, only for memory backend.
+// With FakeClock, advance the clock then call Tick to run tasks deterministically
+func (qu Queue) Tick() (err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	b, ok := qu.broker.(*memoryBroker)
+	if !ok {
+		err = errors.WithStack(ErrTickUnsupported)
+		return
+	}
+	qu.scan()
+	b.process(periodTaskHandler{
+		qu: qu,
+	})
+	if qu.ops.clearArchived > 0 && b.clearDue(qu.ops.clearArchived) {
+		qu.clearArchived()
+	}
+	return
+}
+
+func (qu Queue) now() time.Time {
+	if qu.clock == nil {
+		return time.Now()
+	}
+	return qu.clock.Now()
+}
+
+// get queue name of task, default queue if it empty
+func (qu Queue) getQueue(name string) (queue string, err error) {
+	if name == "" {
+		queue = qu.ops.name
+		return
+	}
+	if _, ok := qu.ops.queues[name]; !ok {
+		err = errors.WithStack(ErrQueueInvalid)
+		return
+	}
+	queue = name
+	return
+}
+
 func (qu Queue) Once(options ...func(*QueueTaskOptions)) (err error) {
 	ops := getQueueTaskOptionsOrSetDefault(nil)
 	for _, f := range options {
@@ -196,9 +352,15 @@ func (qu Queue) Once(options ...func(*QueueTaskOptions)) (err error) {
 		err = errors.WithStack(ErrUuidNil)
 		return
 	}
-	t := asynq.NewTask(ops.name+".once", []byte(ops.payload), asynq.TaskID(ops.uid))
+	var queue string
+	queue, err = qu.getQueue(ops.queue)
+	if err != nil {
+		return
+	}
+	t := asynq.NewTask(ops.name+".once", []byte(ops.payload))
 	taskOpts := []asynq.Option{
-		asynq.Queue(qu.ops.name),
+		asynq.TaskID(ops.uid),
+		asynq.Queue(queue),
 		asynq.MaxRetry(qu.ops.maxRetry),
 		asynq.Timeout(time.Duration(ops.timeout) * time.Second),
 	}
@@ -217,7 +379,11 @@ func (qu Queue) Once(options ...func(*QueueTaskOptions)) (err error) {
 	} else if ops.now {
 		taskOpts = append(taskOpts, asynq.ProcessIn(time.Second))
 	}
-	_, err = qu.client.Enqueue(t, taskOpts...)
+	err = qu.saveCallback(ops)
+	if err != nil {
+		return
+	}
+	err = qu.broker.enqueue(t, taskOpts...)
 	return
 }
 
@@ -230,12 +396,33 @@ func (qu Queue) Cron(options ...func(*QueueTaskOptions)) (err error) {
 		err = errors.WithStack(ErrUuidNil)
 		return
 	}
+	var queue string
+	queue, err = qu.getQueue(ops.queue)
+	if err != nil {
+		return
+	}
+	if ops.timezone != "" {
+		if _, e := time.LoadLocation(ops.timezone); e != nil {
+			err = errors.WithStack(ErrTimezoneInvalid)
+			return
+		}
+	}
+	switch ops.misfire {
+	case constant.DelayMisfireOnce, constant.DelayMisfireAll, constant.DelayMisfireSkip:
+	default:
+		err = errors.WithStack(ErrMisfireInvalid)
+		return
+	}
 	var next int64
-	next, err = getNext(ops.expr, 0)
+	next, err = getNext(ops.expr, qu.now().Unix(), ops.timezone)
 	if err != nil {
 		err = errors.WithStack(ErrExprInvalid)
 		return
 	}
+	var endAt int64
+	if ops.endAt != nil {
+		endAt = ops.endAt.Unix()
+	}
 	t := periodTask{
 		Expr:     ops.expr,
 		Name:     ops.name + ".cron",
@@ -244,8 +431,20 @@ func (qu Queue) Cron(options ...func(*QueueTaskOptions)) (err error) {
 		Next:     next,
 		MaxRetry: ops.maxRetry,
 		Timeout:  ops.timeout,
+		Queue:    queue,
+		Timezone: ops.timezone,
+		Jitter:   ops.jitter,
+		Misfire:  ops.misfire,
+		EndAt:    endAt,
+		MaxRuns:  ops.maxRuns,
+	}
+	err = qu.saveCallback(ops)
+	if err != nil {
+		return
 	}
-	_, err = qu.redis.HSet(context.Background(), qu.ops.redisPeriodKey, ops.uid, utils.Struct2Json(t)).Result()
+	err = qu.kv.hSet(qu.ops.redisPeriodKey, map[string]string{
+		ops.uid: utils.Struct2Json(t),
+	})
 	if err != nil {
 		err = errors.WithStack(ErrSaveCron)
 		return
@@ -253,60 +452,117 @@ func (qu Queue) Cron(options ...func(*QueueTaskOptions)) (err error) {
 	return
 }
 
-func (qu Queue) Remove(uid string) (err error) {
-	var ok bool
-	for {
-		ok = qu.nxLock.Lock()
-		if ok {
-			break
-		}
-		time.Sleep(100 * time.Millisecond)
+// save http callback config of task
+func (qu Queue) saveCallback(ops *QueueTaskOptions) (err error) {
+	if ops.callback == "" {
+		qu.kv.hDel(qu.ops.redisCallbackKey, ops.uid)
+		return
+	}
+	cb := callback{
+		Url:     ops.callback,
+		Headers: ops.callbackHeaders,
+	}
+	err = qu.kv.hSet(qu.ops.redisCallbackKey, map[string]string{
+		ops.uid: utils.Struct2Json(cb),
+	})
+	if err != nil {
+		err = errors.WithStack(ErrSaveCallback)
 	}
-	defer qu.nxLock.Unlock()
-	qu.redis.HDel(context.Background(), qu.ops.redisPeriodKey, uid)
+	return
+}
 
-	err = qu.inspector.DeleteTask(qu.ops.name, uid)
+func (qu Queue) getCallback(uid string) (cb callback) {
+	v, err := qu.kv.hGet(qu.ops.redisCallbackKey, uid)
+	if err == nil {
+		utils.Json2Struct(v, &cb)
+	}
 	return
 }
 
-func (qu Queue) processed(uid string) {
-	var ok bool
-	for {
-		ok = qu.nxLock.Lock()
-		if ok {
-			break
+// last http callback status will be expired after some days
+func (qu Queue) saveCallbackStatus(uid string, cb callback) {
+	qu.kv.set(qu.ops.redisCallbackKey+".status."+uid, utils.Struct2Json(cb), constant.DelayCallbackStatusExpire*24*time.Hour)
+}
+
+func (qu Queue) Remove(uid string) (err error) {
+	qu.lock(qu.ops.redisPeriodKey + ".lock")
+	defer qu.kv.del(qu.ops.redisPeriodKey + ".lock")
+	qu.kv.hDel(qu.ops.redisPeriodKey, uid)
+	qu.kv.hDel(qu.ops.redisCallbackKey, uid)
+
+	// the queue of once task is unknown, try each queue
+	for name := range qu.ops.queues {
+		err = qu.broker.deleteTask(name, uid)
+		if err == nil {
+			return
 		}
-		time.Sleep(100 * time.Millisecond)
 	}
-	defer qu.nxLock.Unlock()
-	ctx := context.Background()
-	t, e := qu.redis.HGet(ctx, qu.ops.redisPeriodKey, uid).Result()
+	return
+}
+
+func (qu Queue) processed(uid string) {
+	qu.lock(qu.ops.redisPeriodKey + ".lock")
+	defer qu.kv.del(qu.ops.redisPeriodKey + ".lock")
+	t, e := qu.kv.hGet(qu.ops.redisPeriodKey, uid)
 	if e == nil || e != redis.Nil {
 		var item periodTask
 		utils.Json2Struct(t, &item)
 		item.Processed++
-		qu.redis.HSet(ctx, qu.ops.redisPeriodKey, uid, utils.Struct2Json(item))
+		qu.kv.hSet(qu.ops.redisPeriodKey, map[string]string{
+			uid: utils.Struct2Json(item),
+		})
 	}
 	return
 }
 
 func (qu Queue) scan() {
-	ctx := context.Background()
-	ok := qu.nxLock.Lock()
+	key := qu.ops.redisPeriodKey + ".lock"
+	ok := qu.kv.setNx(key, 10*time.Second)
 	if !ok {
 		return
 	}
-	defer qu.nxLock.Unlock()
-	m, _ := qu.redis.HGetAll(ctx, qu.ops.redisPeriodKey).Result()
-	p := qu.redis.Pipeline()
+	defer qu.kv.del(key)
+	m, _ := qu.kv.hGetAll(qu.ops.redisPeriodKey)
+	changed := make(map[string]string)
 	ops := qu.ops
+	now := qu.now().Unix()
 	for _, v := range m {
 		var item periodTask
 		utils.Json2Struct(v, &item)
-		next, _ := getNext(item.Expr, item.Next)
-		t := asynq.NewTask(item.Name, []byte(item.Payload), asynq.TaskID(item.Uid))
+		if item.Ended {
+			continue
+		}
+		if (item.EndAt > 0 && item.Next > item.EndAt) || (item.MaxRuns > 0 && item.Runs >= item.MaxRuns) {
+			// keep it for inspection
+			item.Ended = true
+			changed[item.Uid] = utils.Struct2Json(item)
+			continue
+		}
+		processAt := item.Next
+		next, _ := getNext(item.Expr, item.Next, item.Timezone)
+		if item.Next < now-constant.DelayCronMisfireGrace {
+			// scanner was down, missed runs
+			switch item.Misfire {
+			case constant.DelayMisfireSkip:
+				item.Next, _ = getNext(item.Expr, now, item.Timezone)
+				changed[item.Uid] = utils.Struct2Json(item)
+				continue
+			case constant.DelayMisfireOnce:
+				processAt = now
+				next, _ = getNext(item.Expr, now, item.Timezone)
+			}
+		}
+		if item.Jitter > 0 {
+			processAt += rand.Int63n(int64(item.Jitter) + 1)
+		}
+		queue := item.Queue
+		if queue == "" {
+			queue = ops.name
+		}
+		t := asynq.NewTask(item.Name, []byte(item.Payload))
 		taskOpts := []asynq.Option{
-			asynq.Queue(ops.name),
+			asynq.TaskID(item.Uid),
+			asynq.Queue(queue),
 			asynq.MaxRetry(ops.maxRetry),
 			asynq.Timeout(time.Duration(item.Timeout) * time.Second),
 		}
@@ -323,25 +579,33 @@ func (qu Queue) scan() {
 			// set retention avoid repeat in short time
 			taskOpts = append(taskOpts, asynq.Retention(time.Duration(retention)*time.Second))
 		}
-		taskOpts = append(taskOpts, asynq.ProcessAt(time.Unix(item.Next, 0)))
-		_, err := qu.client.Enqueue(t, taskOpts...)
+		taskOpts = append(taskOpts, asynq.ProcessAt(time.Unix(processAt, 0)))
+		err := qu.broker.enqueue(t, taskOpts...)
 		// enqueue success, update next
 		if err == nil {
 			item.Next = next
-			p.HSet(ctx, qu.ops.redisPeriodKey, item.Uid, utils.Struct2Json(item))
+			item.Runs++
+			changed[item.Uid] = utils.Struct2Json(item)
 		}
 	}
 	// batch save to cache
-	p.Exec(ctx)
+	qu.kv.hSet(qu.ops.redisPeriodKey, changed)
 	return
 }
 
 func (qu Queue) clearArchived() {
-	list, err := qu.inspector.ListArchivedTasks(qu.ops.name, asynq.Page(1), asynq.PageSize(100))
+	for name := range qu.ops.queues {
+		qu.clearQueueArchived(name)
+	}
+	qu.clearWorkflows()
+}
+
+func (qu Queue) clearQueueArchived(queue string) {
+	list, err := qu.broker.listTasks(queue, constant.DelayTaskStateArchived, 1, 100)
 	if err != nil {
 		return
 	}
-	ctx := context.Background()
+	now := carbon.Time2Carbon(qu.now())
 	for _, item := range list {
 		last := carbon.Time2Carbon(item.LastFailedAt)
 		if !last.IsZero() && item.Retried < item.MaxRetry {
@@ -351,43 +615,56 @@ func (qu Queue) clearArchived() {
 		var flag bool
 		if strings.HasSuffix(item.Type, ".cron") {
 			// cron task
-			t, e := qu.redis.HGet(ctx, qu.ops.redisPeriodKey, uid).Result()
+			t, e := qu.kv.hGet(qu.ops.redisPeriodKey, uid)
 			if e == nil || e != redis.Nil {
 				var task periodTask
 				utils.Json2Struct(t, &task)
-				next, _ := getNext(task.Expr, task.Next)
+				next, _ := getNext(task.Expr, task.Next, task.Timezone)
 				diff := next - task.Next
 				if diff <= 60 {
-					if carbon.Now().Gt(last.AddMinutes(5)) {
+					if now.Gt(last.AddMinutes(5)) {
 						flag = true
 					}
 				} else if diff <= 600 {
-					if carbon.Now().Gt(last.AddMinutes(30)) {
+					if now.Gt(last.AddMinutes(30)) {
 						flag = true
 					}
 				} else if diff <= 3600 {
-					if carbon.Now().Gt(last.AddHours(2)) {
+					if now.Gt(last.AddHours(2)) {
 						flag = true
 					}
 				} else {
-					if carbon.Now().Gt(last.AddHours(5)) {
+					if now.Gt(last.AddHours(5)) {
 						flag = true
 					}
 				}
 			}
 		} else {
 			// once task, has failed for more than 5 minutes
-			if carbon.Now().Gt(last.AddMinutes(5)) {
+			if now.Gt(last.AddMinutes(5)) {
 				flag = true
 			}
 		}
 		if flag {
-			qu.inspector.DeleteTask(qu.ops.name, uid)
+			qu.broker.deleteTask(queue, uid)
+			if !strings.HasSuffix(item.Type, ".cron") {
+				qu.kv.hDel(qu.ops.redisCallbackKey, uid)
+			}
 		}
 	}
 }
 
-func getNext(expr string, timestamp int64) (next int64, err error) {
+// lock wait until the lock is acquired
+func (qu Queue) lock(key string) {
+	for {
+		if qu.kv.setNx(key, 10*time.Second) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func getNext(expr string, timestamp int64, timezone string) (next int64, err error) {
 	var schedule cron.Schedule
 	schedule, err = cron.ParseStandard(expr)
 	if err != nil {
@@ -397,6 +674,15 @@ func getNext(expr string, timestamp int64) (next int64, err error) {
 	if timestamp > 0 {
 		t = time.Unix(timestamp, 0)
 	}
+	if timezone != "" {
+		// expr is based on the location
+		var loc *time.Location
+		loc, err = time.LoadLocation(timezone)
+		if err != nil {
+			return
+		}
+		t = t.In(loc)
+	}
 	next = schedule.Next(t).Unix()
 	return
 }
@@ -1,15 +1,44 @@
 package delay
 
 import (
+	"context"
 	"fmt"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/req"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
 
+// memory backend with fake clock, no redis required
+func newTestQueue(clock *FakeClock, options ...func(*QueueOptions)) *Queue {
+	return NewQueue(append([]func(*QueueOptions){
+		WithQueueMemory(true),
+		WithQueueClock(clock),
+	}, options...)...)
+}
+
+// advance clock second by second and process due tasks
+func tick(qu *Queue, clock *FakeClock, seconds int) {
+	for i := 0; i < seconds; i++ {
+		clock.Advance(time.Second)
+		qu.Tick()
+	}
+}
+
 func TestNewQueue(t *testing.T) {
+	clock := NewFakeClock(time.Date(2022, 1, 1, 0, 0, 0, 0, time.Local))
+	queues := make([]*Queue, 0)
 	i := 0
 	for i < 10 {
-		qu := NewQueue()
+		qu := newTestQueue(
+			clock,
+			WithQueueHandler(func(ctx context.Context, t Task) error {
+				fmt.Println(clock.Now().Format("15:04:05"), t.Name, t.Uid)
+				return nil
+			}),
+		)
 		// add cron tasks
 		err := qu.Cron(
 			WithQueueTaskUuid("order1"),
@@ -52,25 +81,269 @@ func TestNewQueue(t *testing.T) {
 			WithQueueTaskExpr("0 0 28,29,30,31 * ?"),
 		)
 		fmt.Println(err)
+		queues = append(queues, qu)
 		i++
 	}
 
-	go func() {
-		qu := NewQueue()
-		// add once task
-		qu.Once(
-			WithQueueTaskUuid("once.order"),
-			WithQueueTaskName("once.task"),
-			WithQueueTaskAt(time.Now().Add(time.Duration(240)*time.Hour)),
-		)
-	}()
-	go func() {
-		time.Sleep(5 * time.Minute)
-		// remove task
-		qu := NewQueue()
-		qu.Remove("once.order")
-	}()
+	qu := queues[0]
+	// add once task
+	qu.Once(
+		WithQueueTaskUuid("once.order"),
+		WithQueueTaskName("once.task"),
+		WithQueueTaskAt(clock.Now().Add(time.Duration(240)*time.Hour)),
+	)
+	for j := 0; j < 100; j++ {
+		clock.Advance(time.Second)
+		for _, item := range queues {
+			item.Tick()
+		}
+	}
+	// remove task
+	fmt.Println(qu.Remove("once.order"))
+	fmt.Println(qu.FindPeriodTask())
+}
+
+func TestQueue_Priority(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	qu := newTestQueue(
+		clock,
+		WithQueuePriority(constant.DelayQueueCritical, 6),
+		WithQueuePriority(constant.DelayQueueDefault, 3),
+		WithQueuePriority(constant.DelayQueueLow, 1),
+		WithQueueConcurrencyLimit(constant.DelayQueueLow, 2),
+		WithQueueHandler(func(ctx context.Context, t Task) error {
+			fmt.Println(t.Queue, t.Uid)
+			return nil
+		}),
+	)
+	err := qu.Once(
+		WithQueueTaskUuid("export.order"),
+		WithQueueTaskName("export"),
+		WithQueueTaskQueue(constant.DelayQueueLow),
+		WithQueueTaskNow(true),
+	)
+	err = qu.Once(
+		WithQueueTaskUuid("notify.order"),
+		WithQueueTaskName("notify"),
+		WithQueueTaskQueue(constant.DelayQueueCritical),
+		WithQueueTaskNow(true),
+	)
+	fmt.Println(err)
+	// queue not found
+	fmt.Println(qu.Once(
+		WithQueueTaskUuid("unknown.order"),
+		WithQueueTaskQueue("unknown"),
+	))
+	tick(qu, clock, 1)
+}
+
+func TestQueue_Inspector(t *testing.T) {
+	qu := newTestQueue(NewFakeClock(time.Now()))
+	qu.Once(
+		WithQueueTaskUuid("inspect.order"),
+		WithQueueTaskName("inspect"),
+		WithQueueTaskIn(time.Hour),
+	)
+	qu.Cron(
+		WithQueueTaskUuid("inspect.cron"),
+		WithQueueTaskName("inspect"),
+		WithQueueTaskExpr("@every 1h"),
+	)
+	fmt.Println(qu.FindQueue())
+	fmt.Println(qu.FindTask(&req.DelayTask{
+		State: constant.DelayTaskStateScheduled,
+	}))
+	fmt.Println(qu.FindPeriodTask())
+	fmt.Println(qu.PauseQueue(""))
+	fmt.Println(qu.ResumeQueue(""))
+	fmt.Println(qu.RunTask("", "inspect.order"))
+	fmt.Println(qu.DeleteTask("", "inspect.cron"))
+	// delete a task occurrence will not delete cron definition
+	list, _ := qu.FindPeriodTask()
+	if len(list) != 1 {
+		t.Errorf("cron tasks %d, want 1", len(list))
+	}
+	fmt.Println(qu.Remove("inspect.cron"))
+}
 
-	ch := make(chan int)
-	<-ch
+func TestQueue_Callback(t *testing.T) {
+	times := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		times++
+		fmt.Println(r.Header.Get(constant.MiddlewareSignTokenHeaderKey), r.Header.Get("X-Tenant"))
+		if times < 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("busy"))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	clock := NewFakeClock(time.Now())
+	qu := newTestQueue(
+		clock,
+		WithQueueCallbackSign("app1", "secret1"),
+		WithQueueCallbackHeader("X-Tenant", "t1"),
+		WithQueueCallbackBackoff(2),
+	)
+	qu.Once(
+		WithQueueTaskUuid("callback.order"),
+		WithQueueTaskName("callback"),
+		WithQueueTaskCallback(srv.URL+"/callback?id=1"),
+		WithQueueTaskNow(true),
+	)
+	tick(qu, clock, 1)
+	fmt.Println(qu.GetCallback("callback.order"))
+	// retry after callback backoff
+	tick(qu, clock, 2)
+	if times != 2 {
+		t.Errorf("callback %d times, want 2", times)
+	}
+	fmt.Println(qu.GetCallback("callback.order"))
+}
+
+func TestQueue_Workflow(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	qu := newTestQueue(
+		clock,
+		WithQueueHandler(func(ctx context.Context, t Task) error {
+			fmt.Println("run", t.Name, t.Payload)
+			if t.Name == "upload.once" {
+				return fmt.Errorf("upload failed")
+			}
+			return nil
+		}),
+		WithQueueMaxRetry(0),
+	)
+	wf := NewWorkflow("wf1").
+		Then(WorkflowStep{Name: "export", Payload: "rows"}).
+		Then(WorkflowStep{Name: "zip1"}, WorkflowStep{Name: "zip2"}).
+		Then(WorkflowStep{Name: "upload"}).
+		Then(WorkflowStep{Name: "notify"}).
+		Catch(WorkflowStep{Name: "alert"})
+	fmt.Println(qu.RunWorkflow(wf))
+	tick(qu, clock, 10)
+	fmt.Println(qu.GetWorkflow("wf1"))
+	fmt.Println(qu.RemoveWorkflow("wf1"))
+}
+
+func TestQueue_WorkflowRetention(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	qu := newTestQueue(
+		clock,
+		WithQueueWorkflowRetention(60),
+		WithQueueClearArchived(30),
+	)
+	wf := NewWorkflow("wf2").
+		Then(WorkflowStep{Name: "export"}).
+		Then(WorkflowStep{Name: "notify"})
+	err := qu.RunWorkflow(wf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tick(qu, clock, 5)
+	wf2, err := qu.GetWorkflow("wf2")
+	if err != nil || wf2.State != constant.DelayWorkflowStateSucceeded {
+		t.Fatalf("workflow = %s, %v, want succeeded", wf2.State, err)
+	}
+	// task index is removed once the workflow ended
+	tasks, _ := qu.kv.hGetAll(qu.ops.redisWorkflowKey + ".task")
+	if len(tasks) > 0 {
+		t.Errorf("task index = %v, want empty", tasks)
+	}
+	// removed by clear archived after retention
+	tick(qu, clock, 90)
+	_, err = qu.GetWorkflow("wf2")
+	if err == nil {
+		t.Error("ended workflow is not removed after retention")
+	}
+}
+
+func TestQueue_CronPolicy(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	qu := newTestQueue(clock)
+	err := qu.Cron(
+		WithQueueTaskUuid("policy.order"),
+		WithQueueTaskName("policy"),
+		WithQueueTaskExpr("0 9 * * *"),
+		WithQueueTaskTimezone("Asia/Shanghai"),
+		WithQueueTaskJitter(30),
+		WithQueueTaskMisfire(constant.DelayMisfireOnce),
+		WithQueueTaskEndAt(clock.Now().AddDate(0, 1, 0)),
+		WithQueueTaskMaxRuns(10),
+	)
+	fmt.Println(err)
+	// invalid timezone
+	fmt.Println(qu.Cron(
+		WithQueueTaskUuid("policy.order2"),
+		WithQueueTaskExpr("@every 1m"),
+		WithQueueTaskTimezone("Mars/Base"),
+	))
+	tick(qu, clock, 3)
+	fmt.Println(qu.FindPeriodTask())
+}
+
+func TestQueue_GetResult(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	qu := newTestQueue(
+		clock,
+		WithQueueHandler(func(ctx context.Context, t Task) error {
+			return WriteResult(ctx, []byte(`{"url":"https://example.com/export.zip"}`))
+		}),
+	)
+	qu.Once(
+		WithQueueTaskUuid("result.order"),
+		WithQueueTaskName("result"),
+		WithQueueTaskNow(true),
+		WithQueueTaskRetention(3600),
+	)
+	fmt.Println(qu.GetResult("result.order"))
+	tick(qu, clock, 1)
+	fmt.Println(qu.GetResult("result.order"))
+}
+
+func TestQueue_Memory(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	times := 0
+	qu := newTestQueue(
+		clock,
+		WithQueueHandler(func(ctx context.Context, t Task) error {
+			if t.Uid == "timeout.order" {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			times++
+			return fmt.Errorf("failed %d", times)
+		}),
+		WithQueueMaxRetry(2),
+	)
+	qu.Once(
+		WithQueueTaskUuid("retry.order"),
+		WithQueueTaskName("retry"),
+		WithQueueTaskNow(true),
+	)
+	qu.Once(
+		WithQueueTaskUuid("timeout.order"),
+		WithQueueTaskName("timeout"),
+		WithQueueTaskNow(true),
+		WithQueueTaskTimeout(1),
+		WithQueueTaskMaxRetry(1),
+	)
+	tick(qu, clock, 1)
+	fmt.Println(qu.GetResult("retry.order"))
+	// retry delay: 16s, 31s
+	tick(qu, clock, 60)
+	fmt.Println(qu.FindTask(&req.DelayTask{
+		State: constant.DelayTaskStateArchived,
+	}))
+	if times != 3 {
+		t.Errorf("processed %d times, want 3", times)
+	}
+	// archived tasks are cleared after 5 minutes
+	tick(qu, clock, 600)
+	_, err := qu.GetResult("retry.order")
+	fmt.Println(err)
+	if err == nil {
+		t.Error("archived task is not cleared")
+	}
 }
@@ -0,0 +1,63 @@
+package delay
+
+import (
+	"context"
+	"github.com/golang-module/carbon/v2"
+	"github.com/hibiken/asynq"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/pkg/errors"
+	"io"
+)
+
+// WriteResult save result of the task in handler, it will be kept until retention expired(WithQueueRetention/WithQueueTaskRetention)
+func WriteResult(ctx context.Context, data []byte) (err error) {
+	w, ok := ctx.Value(constant.DelayResultWriterCtxKey).(io.Writer)
+	if !ok || w == nil {
+		err = errors.WithStack(ErrResultWriterNil)
+		return
+	}
+	_, err = w.Write(data)
+	return
+}
+
+// GetResult get state of task with result or last error
+func (qu Queue) GetResult(uid string) (rp resp.DelayTaskResult, err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	var info *asynq.TaskInfo
+	for name := range qu.ops.queues {
+		info, err = qu.broker.getTaskInfo(name, uid)
+		if err == nil {
+			break
+		}
+	}
+	if info == nil {
+		err = errors.WithStack(ErrTaskNotFound)
+		return
+	}
+	rp.Uid = info.ID
+	rp.Queue = info.Queue
+	rp.Name = info.Type
+	rp.Retried = info.Retried
+	rp.MaxRetry = info.MaxRetry
+	rp.Result = string(info.Result)
+	rp.LastErr = info.LastErr
+	switch info.State {
+	case asynq.TaskStateActive:
+		rp.State = constant.DelayResultStateActive
+	case asynq.TaskStateRetry:
+		rp.State = constant.DelayResultStateRetrying
+	case asynq.TaskStateCompleted:
+		rp.State = constant.DelayResultStateCompleted
+		rp.CompletedAt = carbon.DateTime{Carbon: carbon.Time2Carbon(info.CompletedAt)}
+	case asynq.TaskStateArchived:
+		rp.State = constant.DelayResultStateFailed
+	default:
+		// pending/scheduled/aggregating
+		rp.State = constant.DelayResultStatePending
+	}
+	return
+}
@@ -0,0 +1,207 @@
+package delay
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	aliyun "github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/gin-gonic/gin"
+	"github.com/piupuer/go-helper/pkg/log"
+	"github.com/piupuer/go-helper/pkg/oss"
+	"github.com/piupuer/go-helper/pkg/utils"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Storage export file storage backend
+type Storage interface {
+	// Put upload local file as object
+	Put(ctx context.Context, objName, filename string) error
+	// SignUrl download url of object which will be expired
+	SignUrl(ctx context.Context, objName string, expire time.Duration) (string, error)
+	Delete(ctx context.Context, objNames []string) error
+}
+
+// AliyunStorage aliyun oss: https://help.aliyun.com/product/31815.html
+type AliyunStorage struct {
+	endpoint string
+	key      string
+	secret   string
+	bucket   string
+}
+
+func NewAliyunStorage(endpoint, key, secret, bucket string) *AliyunStorage {
+	return &AliyunStorage{
+		endpoint: endpoint,
+		key:      key,
+		secret:   secret,
+		bucket:   bucket,
+	}
+}
+
+func (as AliyunStorage) Put(ctx context.Context, objName, filename string) (err error) {
+	var bucket *aliyun.Bucket
+	bucket, err = as.getBucket(ctx)
+	if err != nil {
+		return
+	}
+	err = bucket.PutObjectFromFile(objName, filename)
+	return
+}
+
+func (as AliyunStorage) SignUrl(ctx context.Context, objName string, expire time.Duration) (rp string, err error) {
+	var bucket *aliyun.Bucket
+	bucket, err = as.getBucket(ctx)
+	if err != nil {
+		return
+	}
+	rp, err = bucket.SignURL(objName, http.MethodGet, int64(expire/time.Second))
+	return
+}
+
+func (as AliyunStorage) Delete(ctx context.Context, objNames []string) (err error) {
+	var bucket *aliyun.Bucket
+	bucket, err = as.getBucket(ctx)
+	if err != nil {
+		return
+	}
+	_, err = bucket.DeleteObjects(objNames)
+	return
+}
+
+func (as AliyunStorage) getBucket(ctx context.Context) (bucket *aliyun.Bucket, err error) {
+	var client *aliyun.Client
+	client, err = aliyun.New(as.endpoint, as.key, as.secret)
+	if err != nil {
+		log.WithContext(ctx).Error(errors.Wrap(ErrOssSecretInvalid, err.Error()))
+		err = errors.WithStack(ErrOssSecretInvalid)
+		return
+	}
+	bucket, err = client.Bucket(as.bucket)
+	if err != nil {
+		log.WithContext(ctx).Error(errors.Wrap(ErrOssBucketInvalid, err.Error()))
+		err = errors.WithStack(ErrOssBucketInvalid)
+		return
+	}
+	return
+}
+
+// MinioStorage self-hosted minio: https://min.io
+type MinioStorage struct {
+	mo     *oss.MinioOss
+	bucket string
+}
+
+func NewMinioStorage(mo *oss.MinioOss, bucket string) *MinioStorage {
+	return &MinioStorage{
+		mo:     mo,
+		bucket: bucket,
+	}
+}
+
+func (m MinioStorage) Put(ctx context.Context, objName, filename string) error {
+	return m.mo.PutLocal(ctx, m.bucket, objName, filename)
+}
+
+func (m MinioStorage) SignUrl(ctx context.Context, objName string, expire time.Duration) (string, error) {
+	return m.mo.GetPresignedUrl(ctx, m.bucket, objName, expire)
+}
+
+func (m MinioStorage) Delete(ctx context.Context, objNames []string) error {
+	return m.mo.BatchRemove(ctx, m.bucket, objNames)
+}
+
+// LocalStorage save file to local dir, the signed url should be served by Handler
+type LocalStorage struct {
+	dir       string
+	urlPrefix string
+	secret    string
+}
+
+// NewLocalStorage urlPrefix is the route prefix of Handler, example: http://127.0.0.1:8080/api/delay/export/file
+func NewLocalStorage(dir, urlPrefix, secret string) (rp *LocalStorage, err error) {
+	if secret == "" {
+		// anyone can sign url by empty key
+		err = errors.WithStack(ErrLocalSecretNil)
+		return
+	}
+	rp = &LocalStorage{
+		dir:       dir,
+		urlPrefix: strings.TrimSuffix(urlPrefix, "/"),
+		secret:    secret,
+	}
+	return
+}
+
+func (ls LocalStorage) Put(ctx context.Context, objName, filename string) (err error) {
+	dst := filepath.Join(ls.dir, filepath.FromSlash(objName))
+	err = os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+	if err != nil {
+		return
+	}
+	var src, f *os.File
+	src, err = os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+	f, err = os.Create(dst)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_, err = io.Copy(f, src)
+	return
+}
+
+func (ls LocalStorage) SignUrl(ctx context.Context, objName string, expire time.Duration) (rp string, err error) {
+	expires := fmt.Sprintf("%d", time.Now().Add(expire).Unix())
+	v := url.Values{}
+	v.Set("expires", expires)
+	v.Set("signature", ls.sign(objName, expires))
+	// file name may contain #, ? or %
+	segments := strings.Split(objName, "/")
+	for i, item := range segments {
+		segments[i] = url.PathEscape(item)
+	}
+	rp = fmt.Sprintf("%s/%s?%s", ls.urlPrefix, strings.Join(segments, "/"), v.Encode())
+	return
+}
+
+func (ls LocalStorage) Delete(ctx context.Context, objNames []string) (err error) {
+	for _, item := range objNames {
+		e := os.Remove(filepath.Join(ls.dir, filepath.FromSlash(item)))
+		if e != nil && !os.IsNotExist(e) {
+			err = e
+			return
+		}
+	}
+	return
+}
+
+// Handler serve signed url, route path must end with '*name', example: router.GET("/delay/export/file/*name", ls.Handler())
+func (ls LocalStorage) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		objName := strings.TrimPrefix(c.Param("name"), "/")
+		expires := c.Query("expires")
+		if objName == "" || strings.Contains(objName, "..") || utils.Str2Int64(expires) < time.Now().Unix() || !hmac.Equal([]byte(ls.sign(objName, expires)), []byte(c.Query("signature"))) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.FileAttachment(filepath.Join(ls.dir, filepath.FromSlash(objName)), filepath.Base(objName))
+	}
+}
+
+func (ls LocalStorage) sign(objName, expires string) string {
+	hash := hmac.New(sha256.New, []byte(ls.secret))
+	hash.Write([]byte(objName + "|" + expires))
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,429 @@
+package delay
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/log"
+	"github.com/piupuer/go-helper/pkg/utils"
+	"github.com/pkg/errors"
+	"strconv"
+)
+
+// Workflow DAG of tasks, each step runs only if all deps succeeded
+type Workflow struct {
+	Uid       string         `json:"uid"`
+	State     string         `json:"state"` // running/succeeded/failed
+	Steps     []WorkflowStep `json:"steps"`
+	CreatedAt int64          `json:"createdAt"`
+	UpdatedAt int64          `json:"updatedAt"`
+	Error     error          `json:"-"`
+	// steps of last Then, next Then will depend on them
+	last []string
+}
+
+type WorkflowStep struct {
+	Name    string   `json:"name"`
+	Payload string   `json:"payload"`
+	Queue   string   `json:"queue"` // default queue if it empty
+	Deps    []string `json:"deps"`
+	// failure handler step name
+	OnFail string `json:"onFail"`
+	// failure handler step only runs when other step failed
+	Handler   bool   `json:"handler"`
+	State     string `json:"state"` // pending/running/succeeded/failed/skipped
+	Err       string `json:"err"`
+	StartedAt int64  `json:"startedAt"`
+	EndedAt   int64  `json:"endedAt"`
+}
+
+// NewWorkflow example: export -> (zip1, zip2) -> upload -> notify
+//
+//	NewWorkflow("wf1").
+//		Then(WorkflowStep{Name: "export"}).
+//		Then(WorkflowStep{Name: "zip1"}, WorkflowStep{Name: "zip2"}).
+//		Then(WorkflowStep{Name: "upload"}).
+//		Then(WorkflowStep{Name: "notify"}).
+//		Catch(WorkflowStep{Name: "alert"})
+func NewWorkflow(uid string) *Workflow {
+	wf := &Workflow{
+		Uid:   uid,
+		Steps: make([]WorkflowStep, 0),
+		last:  make([]string, 0),
+	}
+	if uid == "" {
+		wf.Error = errors.WithStack(ErrUuidNil)
+	}
+	return wf
+}
+
+// Add step with custom deps
+func (wf *Workflow) Add(step WorkflowStep) *Workflow {
+	if wf.Error != nil {
+		return wf
+	}
+	if step.Name == "" || wf.getStep(step.Name) != nil {
+		wf.Error = errors.Wrap(ErrWorkflowStepInvalid, step.Name)
+		return wf
+	}
+	step.Handler = false
+	wf.Steps = append(wf.Steps, step)
+	wf.last = []string{step.Name}
+	return wf
+}
+
+// Then chain steps after last steps, multiple steps run in parallel and next Then joins them
+func (wf *Workflow) Then(steps ...WorkflowStep) *Workflow {
+	if wf.Error != nil {
+		return wf
+	}
+	deps := wf.last
+	last := make([]string, 0)
+	for _, item := range steps {
+		// Add changes last, parallel steps must not depend on each other
+		item.Deps = append(item.Deps, deps...)
+		wf.Add(item)
+		last = append(last, item.Name)
+	}
+	wf.last = last
+	return wf
+}
+
+// Catch failure handler of steps(all steps if names is empty)
+func (wf *Workflow) Catch(handler WorkflowStep, names ...string) *Workflow {
+	if wf.Error != nil {
+		return wf
+	}
+	if handler.Name == "" || wf.getStep(handler.Name) != nil {
+		wf.Error = errors.Wrap(ErrWorkflowStepInvalid, handler.Name)
+		return wf
+	}
+	handler.Deps = nil
+	handler.Handler = true
+	wf.Steps = append(wf.Steps, handler)
+	for i, item := range wf.Steps {
+		if item.Handler {
+			continue
+		}
+		if len(names) == 0 || utils.Contains(names, item.Name) {
+			wf.Steps[i].OnFail = handler.Name
+		}
+	}
+	return wf
+}
+
+func (wf *Workflow) getStep(name string) *WorkflowStep {
+	for i, item := range wf.Steps {
+		if item.Name == name {
+			return &wf.Steps[i]
+		}
+	}
+	return nil
+}
+
+// check deps exist and no cycle
+func (wf *Workflow) check() (err error) {
+	if wf.Error != nil {
+		err = wf.Error
+		return
+	}
+	degree := make(map[string]int)
+	next := make(map[string][]string)
+	for _, item := range wf.Steps {
+		if item.Handler {
+			continue
+		}
+		degree[item.Name] += 0
+		for _, dep := range item.Deps {
+			s := wf.getStep(dep)
+			if s == nil || s.Handler {
+				err = errors.Wrap(ErrWorkflowStepInvalid, dep)
+				return
+			}
+			degree[item.Name]++
+			next[dep] = append(next[dep], item.Name)
+		}
+	}
+	if len(degree) == 0 {
+		err = errors.WithStack(ErrWorkflowStepInvalid)
+		return
+	}
+	queue := make([]string, 0)
+	for name, d := range degree {
+		if d == 0 {
+			queue = append(queue, name)
+		}
+	}
+	visited := 0
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		visited++
+		for _, item := range next[name] {
+			degree[item]--
+			if degree[item] == 0 {
+				queue = append(queue, item)
+			}
+		}
+	}
+	if visited != len(degree) {
+		err = errors.WithStack(ErrWorkflowCycle)
+	}
+	return
+}
+
+// RunWorkflow save workflow state to redis and start steps without deps
+func (qu Queue) RunWorkflow(wf *Workflow) (err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	err = wf.check()
+	if err != nil {
+		return
+	}
+	for _, item := range wf.Steps {
+		if _, err = qu.getQueue(item.Queue); err != nil {
+			return
+		}
+	}
+	key := qu.getWorkflowLockKey(wf.Uid)
+	qu.lock(key)
+	defer qu.kv.del(key)
+	exists, _ := qu.kv.hExists(qu.ops.redisWorkflowKey, wf.Uid)
+	if exists {
+		err = errors.WithStack(ErrWorkflowExists)
+		return
+	}
+	now := qu.now().Unix()
+	wf.State = constant.DelayWorkflowStateRunning
+	wf.CreatedAt = now
+	for i := range wf.Steps {
+		wf.Steps[i].State = constant.DelayWorkflowStatePending
+	}
+	err = qu.startWorkflowSteps(wf)
+	return
+}
+
+// GetWorkflow get workflow state by uid
+func (qu Queue) GetWorkflow(uid string) (wf Workflow, err error) {
+	if qu.Error != nil {
+		err = qu.Error
+		return
+	}
+	var v string
+	v, err = qu.kv.hGet(qu.ops.redisWorkflowKey, uid)
+	if err == redis.Nil {
+		err = errors.WithStack(ErrWorkflowNotFound)
+		return
+	}
+	if err != nil {
+		return
+	}
+	utils.Json2Struct(v, &wf)
+	return
+}
+
+// RemoveWorkflow remove workflow state and pending tasks
+func (qu Queue) RemoveWorkflow(uid string) (err error) {
+	var wf Workflow
+	wf, err = qu.GetWorkflow(uid)
+	if err != nil {
+		return
+	}
+	for _, item := range wf.Steps {
+		taskUid := getWorkflowTaskUid(uid, item.Name)
+		if item.State == constant.DelayWorkflowStateRunning {
+			qu.Remove(taskUid)
+		}
+		qu.kv.hDel(qu.ops.redisWorkflowKey+".task", taskUid)
+	}
+	qu.kv.hDel(qu.ops.redisWorkflowKey+".ended", uid)
+	err = qu.kv.hDel(qu.ops.redisWorkflowKey, uid)
+	return
+}
+
+// start pending steps whose deps all succeeded(and failure handlers), then save state
+func (qu Queue) startWorkflowSteps(wf *Workflow, handlers ...WorkflowStep) (err error) {
+	now := qu.now().Unix()
+	ready := make([]WorkflowStep, 0)
+	if wf.State == constant.DelayWorkflowStateRunning {
+		succeeded := true
+		for i, item := range wf.Steps {
+			if item.Handler {
+				continue
+			}
+			if item.State != constant.DelayWorkflowStateSucceeded {
+				succeeded = false
+			}
+			if item.State != constant.DelayWorkflowStatePending {
+				continue
+			}
+			flag := true
+			for _, dep := range item.Deps {
+				if wf.getStep(dep).State != constant.DelayWorkflowStateSucceeded {
+					flag = false
+					break
+				}
+			}
+			if flag {
+				wf.Steps[i].State = constant.DelayWorkflowStateRunning
+				wf.Steps[i].StartedAt = now
+				ready = append(ready, wf.Steps[i])
+			}
+		}
+		if succeeded {
+			wf.State = constant.DelayWorkflowStateSucceeded
+		}
+	}
+	ready = append(ready, handlers...)
+	wf.UpdatedAt = now
+	// save state before enqueue, the step may finish immediately
+	tasks := make(map[string]string)
+	for _, item := range ready {
+		tasks[getWorkflowTaskUid(wf.Uid, item.Name)] = wf.Uid
+	}
+	err = qu.kv.hSet(qu.ops.redisWorkflowKey+".task", tasks)
+	if err == nil {
+		err = qu.kv.hSet(qu.ops.redisWorkflowKey, map[string]string{
+			wf.Uid: utils.Struct2Json(wf),
+		})
+	}
+	if err != nil {
+		err = errors.WithStack(ErrSaveWorkflow)
+		return
+	}
+	for _, item := range ready {
+		err = qu.Once(
+			WithQueueTaskUuid(getWorkflowTaskUid(wf.Uid, item.Name)),
+			WithQueueTaskName(item.Name),
+			WithQueueTaskPayload(item.Payload),
+			WithQueueTaskQueue(item.Queue),
+			WithQueueTaskNow(true),
+		)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// workflowProcessed update step state when the task of step processed
+func (qu Queue) workflowProcessed(ctx context.Context, task Task, e error, retry bool) {
+	if e != nil && retry {
+		// it will be retried
+		return
+	}
+	uid, err := qu.kv.hGet(qu.ops.redisWorkflowKey+".task", task.Uid)
+	if err != nil {
+		// not a workflow step
+		return
+	}
+	key := qu.getWorkflowLockKey(uid)
+	qu.lock(key)
+	defer qu.kv.del(key)
+	wf, err := qu.GetWorkflow(uid)
+	if err != nil {
+		return
+	}
+	var step *WorkflowStep
+	for i, item := range wf.Steps {
+		if getWorkflowTaskUid(uid, item.Name) == task.Uid {
+			step = &wf.Steps[i]
+			break
+		}
+	}
+	if step == nil || step.State != constant.DelayWorkflowStateRunning {
+		return
+	}
+	now := qu.now().Unix()
+	step.EndedAt = now
+	if e == nil {
+		step.State = constant.DelayWorkflowStateSucceeded
+	} else {
+		step.State = constant.DelayWorkflowStateFailed
+		step.Err = e.Error()
+	}
+	handlers := make([]WorkflowStep, 0)
+	if e != nil && !step.Handler {
+		wf.State = constant.DelayWorkflowStateFailed
+		onFail := step.OnFail
+		for i, item := range wf.Steps {
+			if !item.Handler && item.State == constant.DelayWorkflowStatePending {
+				wf.Steps[i].State = constant.DelayWorkflowStateSkipped
+			}
+			// each handler runs once even if parallel steps failed
+			if item.Handler && item.Name == onFail && item.State == constant.DelayWorkflowStatePending {
+				wf.Steps[i].State = constant.DelayWorkflowStateRunning
+				wf.Steps[i].StartedAt = now
+				handlers = append(handlers, wf.Steps[i])
+			}
+		}
+	}
+	err = qu.startWorkflowSteps(&wf, handlers...)
+	if err != nil {
+		log.
+			WithContext(ctx).
+			WithFields(map[string]interface{}{
+				"Workflow": uid,
+				"Task":     task.Uid,
+			}).
+			WithError(err).
+			Error("start workflow steps failed")
+		return
+	}
+	if wf.State != constant.DelayWorkflowStateRunning && !wf.hasRunningStep() {
+		qu.workflowEnded(wf)
+	}
+}
+
+func (wf Workflow) hasRunningStep() bool {
+	for _, item := range wf.Steps {
+		if item.State == constant.DelayWorkflowStateRunning {
+			return true
+		}
+	}
+	return false
+}
+
+// workflowEnded remove task index of ended workflow, its state will be removed after retention
+func (qu Queue) workflowEnded(wf Workflow) {
+	fields := make([]string, 0)
+	for _, item := range wf.Steps {
+		fields = append(fields, getWorkflowTaskUid(wf.Uid, item.Name))
+	}
+	qu.kv.hDel(qu.ops.redisWorkflowKey+".task", fields...)
+	qu.kv.hSet(qu.ops.redisWorkflowKey+".ended", map[string]string{
+		wf.Uid: strconv.FormatInt(wf.UpdatedAt, 10),
+	})
+	qu.clearWorkflows()
+}
+
+// clearWorkflows remove ended workflows which exceeded retention
+func (qu Queue) clearWorkflows() {
+	m, err := qu.kv.hGetAll(qu.ops.redisWorkflowKey + ".ended")
+	if err != nil {
+		return
+	}
+	now := qu.now().Unix()
+	uids := make([]string, 0)
+	for uid, v := range m {
+		ended, _ := strconv.ParseInt(v, 10, 64)
+		if now-ended >= int64(qu.ops.workflowRetention) {
+			uids = append(uids, uid)
+		}
+	}
+	if len(uids) == 0 {
+		return
+	}
+	qu.kv.hDel(qu.ops.redisWorkflowKey, uids...)
+	qu.kv.hDel(qu.ops.redisWorkflowKey+".ended", uids...)
+}
+
+func (qu Queue) getWorkflowLockKey(uid string) string {
+	return qu.ops.redisWorkflowKey + ".lock." + uid
+}
+
+func getWorkflowTaskUid(uid, name string) string {
+	return uid + "." + name
+}
@@ -0,0 +1,141 @@
+package fsm
+
+import (
+	"fmt"
+	"github.com/golang-module/carbon/v2"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/i18n"
+	"github.com/piupuer/go-helper/pkg/req"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/pkg/errors"
+)
+
+// GetAnalytics approval latency/rejection rate per level, pending count per approver and completed per day of a category(default last 30 days)
+func (fs *Fsm) GetAnalytics(r req.FsmAnalytics) (rp resp.FsmAnalytics) {
+	rp.Levels = make([]resp.FsmLevelAnalytics, 0)
+	rp.Approvers = make([]resp.FsmApproverPending, 0)
+	rp.Completed = make([]resp.FsmDailyCompleted, 0)
+	if fs.Error != nil {
+		return
+	}
+	if uint(r.Category) == constant.Zero {
+		fs.AddError(errors.Wrap(i18n.E(ErrParams), "category"))
+		return
+	}
+	end := r.EndAt.Carbon
+	if r.EndAt.IsZero() {
+		end = carbon.Now()
+	}
+	start := r.StartAt.Carbon
+	if r.StartAt.IsZero() {
+		start = end.SubDays(30)
+	}
+	if !end.Gt(start) {
+		fs.AddError(errors.Wrap(i18n.E(ErrParams), "endAt"))
+		return
+	}
+	startAt := start.ToDateTimeString()
+	endAt := end.ToDateTimeString()
+	logTable := fs.tableName("Log")
+	eventTable := fs.tableName("Event")
+	itemTable := fs.tableName("EventItem")
+
+	// each approved/refused log is a step waiting at the level of next event
+	// p95 is the smallest duration whose cumulative distribution >= 0.95(MySQL 8.0+ window function)
+	fs.AddError(
+		fs.session.Raw(
+			fmt.Sprintf(
+				`SELECT t.level, t.name, COUNT(*) AS total,
+					SUM(t.approved = ?) AS refused,
+					SUM(t.approved = ?) / COUNT(*) AS rejection_rate,
+					AVG(t.duration) AS avg_seconds,
+					MIN(CASE WHEN t.cd >= 0.95 THEN t.duration END) AS p95_seconds
+				FROM (
+					SELECT e.level, i.name, l.approved,
+						TIMESTAMPDIFF(SECOND, l.created_at, l.updated_at) AS duration,
+						CUME_DIST() OVER (PARTITION BY e.level, i.name ORDER BY TIMESTAMPDIFF(SECOND, l.created_at, l.updated_at)) AS cd
+					FROM %s l
+					JOIN %s e ON e.id = l.next_event_id
+					JOIN %s i ON i.id = e.name_id
+					WHERE l.deleted_at IS NULL
+					AND l.category = ?
+					AND l.approved IN (?)
+					AND l.created_at BETWEEN ? AND ?
+				) t
+				GROUP BY t.level, t.name
+				ORDER BY t.level`,
+				logTable, eventTable, itemTable,
+			),
+			constant.FsmLogStatusRefused,
+			constant.FsmLogStatusRefused,
+			r.Category,
+			[]uint{constant.FsmLogStatusApproved, constant.FsmLogStatusRefused},
+			startAt,
+			endAt,
+		).
+			Scan(&rp.Levels).
+			Error,
+	)
+
+	// current backlog of approvers(not limited by date range)
+	for _, item := range []struct {
+		table  string
+		column string
+	}{
+		{fs.tableName("LogApprovalUserRelation"), "user_id"},
+		{fs.tableName("LogApprovalRoleRelation"), "role_id"},
+	} {
+		list := make([]resp.FsmApproverPending, 0)
+		fs.AddError(
+			fs.session.Raw(
+				fmt.Sprintf(
+					`SELECT r.%s, COUNT(*) AS pending
+					FROM %s r
+					JOIN %s l ON l.id = r.log_id
+					WHERE l.deleted_at IS NULL
+					AND l.category = ?
+					AND l.approved = ?
+					AND r.%s > 0
+					GROUP BY r.%s
+					ORDER BY pending DESC`,
+					item.column, item.table, logTable, item.column, item.column,
+				),
+				r.Category,
+				constant.FsmLogStatusWaiting,
+			).
+				Scan(&list).
+				Error,
+		)
+		rp.Approvers = append(rp.Approvers, list...)
+	}
+
+	// end log is created when the last level approved
+	fs.AddError(
+		fs.session.Raw(
+			fmt.Sprintf(
+				`SELECT DATE_FORMAT(l.created_at, '%%Y-%%m-%%d') AS date, COUNT(*) AS count
+				FROM %s l
+				WHERE l.deleted_at IS NULL
+				AND l.category = ?
+				AND l.approved = ?
+				AND l.next_event_id = 0
+				AND l.created_at BETWEEN ? AND ?
+				GROUP BY date
+				ORDER BY date`,
+				logTable,
+			),
+			r.Category,
+			constant.FsmLogStatusApproved,
+			startAt,
+			endAt,
+		).
+			Scan(&rp.Completed).
+			Error,
+	)
+	return
+}
+
+// table name with prefix
+func (fs *Fsm) tableName(name string) string {
+	return fs.session.NamingStrategy.TableName(name)
+}
@@ -0,0 +1,37 @@
+package fsm
+
+import (
+	"context"
+	"github.com/piupuer/go-helper/pkg/constant"
+)
+
+// AfterCommit run fun after the approval transaction commits(Flush), use it in hook/notify for side effects like msg push.
+// fun runs at once if ctx is not passed by fsm
+func AfterCommit(ctx context.Context, fun func()) {
+	if ctx != nil {
+		if fs, ok := ctx.Value(constant.FsmAfterCommitCtxKey).(*Fsm); ok && fs != nil {
+			fs.onCommit(fun)
+			return
+		}
+	}
+	fun()
+}
+
+// Flush run side effects(remind/timeout tasks, AfterCommit funcs) of approval, call it after the transaction commits.
+// Drop the Fsm without Flush if the transaction rolls back
+func (fs *Fsm) Flush() {
+	list := fs.afterCommit
+	fs.afterCommit = nil
+	for _, fun := range list {
+		fun()
+	}
+}
+
+func (fs *Fsm) onCommit(fun func()) {
+	fs.afterCommit = append(fs.afterCommit, fun)
+}
+
+// ctx of hook/notify, AfterCommit queues fun to this fsm
+func (fs *Fsm) hookCtx() context.Context {
+	return context.WithValue(fs.ops.ctx, constant.FsmAfterCommitCtxKey, fs)
+}
@@ -0,0 +1,110 @@
+package fsm
+
+import (
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/piupuer/go-helper/pkg/utils"
+	"sort"
+	"strings"
+)
+
+// compare submitter detail json, nested keys are flattened to path(example: order.amount), arrays are compared as json str
+func diffDetail(oldDetail, newDetail string) (rp []resp.FsmDetailDiff) {
+	rp = make([]resp.FsmDetailDiff, 0)
+	if oldDetail == newDetail {
+		return
+	}
+	m1 := flattenDetail(oldDetail)
+	m2 := flattenDetail(newDetail)
+	update := make(map[string]interface{})
+	utils.CompareDiff(m1, m2, &update)
+	for k, v2 := range m2 {
+		v1, ok := m1[k]
+		if !ok {
+			rp = append(rp, resp.FsmDetailDiff{
+				Path:   k,
+				Action: constant.FsmDetailDiffAdd,
+				New:    v2,
+			})
+			continue
+		}
+		if v, ok := update[k]; ok {
+			rp = append(rp, resp.FsmDetailDiff{
+				Path:   k,
+				Action: constant.FsmDetailDiffUpdate,
+				Old:    v1,
+				New:    v,
+			})
+			continue
+		}
+		// CompareDiff skips new null value
+		if v2 == nil && v1 != nil {
+			rp = append(rp, resp.FsmDetailDiff{
+				Path:   k,
+				Action: constant.FsmDetailDiffUpdate,
+				Old:    v1,
+				New:    v2,
+			})
+		}
+	}
+	for k, v1 := range m1 {
+		if _, ok := m2[k]; !ok {
+			rp = append(rp, resp.FsmDetailDiff{
+				Path:   k,
+				Action: constant.FsmDetailDiffRemove,
+				Old:    v1,
+			})
+		}
+	}
+	sort.Slice(rp, func(i, j int) bool {
+		return rp[i].Path < rp[j].Path
+	})
+	return
+}
+
+func flattenDetail(detail string) map[string]interface{} {
+	m := make(map[string]interface{})
+	params := make(map[string]interface{})
+	if detail != "" {
+		utils.Json2Struct(detail, &m)
+	}
+	flattenGuardParams("", m, params)
+	for k, v := range params {
+		if _, ok := v.([]interface{}); ok {
+			params[k] = utils.Struct2Json(v)
+		}
+	}
+	return params
+}
+
+// split edit fields by comma, empty means all fields can be edited
+func splitEditFields(editFields string) []string {
+	fields := make([]string, 0)
+	for _, item := range strings.Split(editFields, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			fields = append(fields, utils.SnakeCase(item))
+		}
+	}
+	return fields
+}
+
+// the path can be edited if itself or its parent is in fields, example: fields=order => order.amount can be edited
+func matchEditField(fields []string, path string) bool {
+	path = utils.SnakeCase(path)
+	for _, item := range fields {
+		if item == path || strings.HasPrefix(path, item+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func getDetailDiff(detailDiff string) []resp.FsmDetailDiff {
+	if detailDiff == "" {
+		return nil
+	}
+	rp := make([]resp.FsmDetailDiff, 0)
+	utils.Json2Struct(detailDiff, &rp)
+	return rp
+}
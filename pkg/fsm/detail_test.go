@@ -0,0 +1,79 @@
+package fsm
+
+import (
+	"fmt"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"testing"
+)
+
+func TestDiffDetail(t *testing.T) {
+	cases := []struct {
+		old  string
+		new  string
+		want []string
+	}{
+		{`{"amount":1}`, `{"amount":1}`, []string{}},
+		{`{"amount":1}`, `{"amount":2}`, []string{"amount update 1 2"}},
+		{
+			`{"order":{"amount":1,"address":"a"}}`,
+			`{"order":{"amount":1,"remark":"r"}}`,
+			[]string{"order.address remove a <nil>", "order.remark add <nil> r"},
+		},
+		{`{"remark":"r"}`, `{"remark":null}`, []string{"remark update r <nil>"}},
+		{`{"tags":["x"]}`, `{"tags":["x","y"]}`, []string{`tags update ["x"] ["x","y"]`}},
+		{``, `{"amount":1}`, []string{"amount add <nil> 1"}},
+	}
+	for _, item := range cases {
+		rp := diffDetail(item.old, item.new)
+		got := make([]string, 0)
+		for _, diff := range rp {
+			got = append(got, fmt.Sprintf("%s %s %v %v", diff.Path, diff.Action, diff.Old, diff.New))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(item.want) {
+			t.Errorf("diffDetail(%s, %s) = %v, want %v", item.old, item.new, got, item.want)
+		}
+	}
+	rp := diffDetail(`{"a":1}`, `{"a":2,"b":1}`)
+	if len(rp) != 2 || rp[0].Action != constant.FsmDetailDiffUpdate || rp[1].Action != constant.FsmDetailDiffAdd {
+		t.Errorf("diffDetail actions = %+v", rp)
+	}
+}
+
+func TestSplitEditFields(t *testing.T) {
+	cases := []struct {
+		fields string
+		want   []string
+	}{
+		{"", []string{}},
+		{" , ", []string{}},
+		{"amount, remark", []string{"amount", "remark"}},
+		{"orderAmount,order.address", []string{"order_amount", "order.address"}},
+	}
+	for _, item := range cases {
+		got := splitEditFields(item.fields)
+		if fmt.Sprint(got) != fmt.Sprint(item.want) {
+			t.Errorf("splitEditFields(%s) = %v, want %v", item.fields, got, item.want)
+		}
+	}
+}
+
+func TestMatchEditField(t *testing.T) {
+	fields := []string{"order", "remark", "user.name"}
+	cases := []struct {
+		path  string
+		match bool
+	}{
+		{"order", true},
+		{"order.amount", true},
+		{"remark", true},
+		{"remarks", false},
+		{"user.name", true},
+		{"user.age", false},
+		{"orders.amount", false},
+	}
+	for _, item := range cases {
+		if match := matchEditField(fields, item.path); match != item.match {
+			t.Errorf("matchEditField(%s) = %v, want %v", item.path, match, item.match)
+		}
+	}
+}
@@ -15,4 +15,10 @@ var (
 	ErrStartedCannotCancel      = "go-helper.fsm.error.started-cannot-cancel"
 	ErrMachineNotFound          = "go-helper.fsm.error.machine-not-found"
 	ErrDuplicateMachineCategory = "go-helper.fsm.error.duplicate-machine-category"
+	ErrRepeatApprove            = "go-helper.fsm.error.repeat-approve"
+	ErrIllegalGuard             = "go-helper.fsm.error.illegal-guard"
+	ErrIllegalRejectLevel       = "go-helper.fsm.error.illegal-reject-level"
+	ErrOnlySubmitterWithdraw    = "go-helper.fsm.error.only-submitter-can-withdraw"
+	ErrActedCannotWithdraw      = "go-helper.fsm.error.acted-cannot-withdraw"
+	ErrCountersignAddSign       = "go-helper.fsm.error.countersign-add-sign"
 )
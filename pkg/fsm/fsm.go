@@ -2,8 +2,11 @@ package fsm
 
 import (
 	"fmt"
+	"github.com/Knetic/govaluate"
+	"github.com/golang-module/carbon/v2"
 	"github.com/looplab/fsm"
 	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/delay"
 	"github.com/piupuer/go-helper/pkg/i18n"
 	"github.com/piupuer/go-helper/pkg/log"
 	"github.com/piupuer/go-helper/pkg/req"
@@ -15,12 +18,15 @@ import (
 	"gorm.io/gorm/schema"
 	"sort"
 	"strings"
+	"time"
 )
 
 type Fsm struct {
 	ops     Options
 	session *gorm.DB
-	Error   error
+	// side effects run by Flush after the transaction commits
+	afterCommit []func()
+	Error       error
 }
 
 // Migrate mysql DDL migrate rollback is not supported, Migrate before New
@@ -39,6 +45,8 @@ func Migrate(options ...func(*Options)) (err error) {
 		new(EventItem),
 		new(Log),
 		new(LogApprovalUserRelation),
+		new(LogVote),
+		new(Delegation),
 	)
 	return
 }
@@ -70,6 +78,7 @@ func (fs *Fsm) DeleteMachineByIds(ids []uint) {
 	fs.session.
 		Model(&Machine{}).
 		Where("id IN (?)", ids).
+		Where("head_id = ?", constant.Zero).
 		Find(&machines)
 	categories := make([]uint, 0)
 	for _, item := range machines {
@@ -78,8 +87,9 @@ func (fs *Fsm) DeleteMachineByIds(ids []uint) {
 	for _, item := range categories {
 		fs.CancelLog(item)
 	}
+	// delete history versions together
 	fs.session.
-		Where("id IN (?)", ids).
+		Where("id IN (?) OR head_id IN (?)", ids, ids).
 		Delete(&Machine{})
 	return
 }
@@ -95,6 +105,7 @@ func (fs *Fsm) CreateMachine(r req.FsmCreateMachine) (rp Machine) {
 	fs.session.
 		Model(&machine).
 		Where("category = ?", machine.Category).
+		Where("head_id = ?", constant.Zero).
 		Count(&count)
 	if count > 0 {
 		fs.AddError(i18n.E(ErrDuplicateMachineCategory))
@@ -102,6 +113,7 @@ func (fs *Fsm) CreateMachine(r req.FsmCreateMachine) (rp Machine) {
 	}
 	// save json for query
 	machine.EventsJson = utils.Struct2Json(r.Levels)
+	machine.Version = constant.One
 	fs.session.Create(&machine)
 	// batch fsm event
 	fs.batchCreateEvent(machine.Id, r.Levels)
@@ -124,21 +136,46 @@ func (fs *Fsm) UpdateMachineById(id uint, r req.FsmUpdateMachine) (rp Machine) {
 	fs.session.
 		Model(&Machine{}).
 		Where("id = ?", id).
+		Where("head_id = ?", constant.Zero).
 		First(&oldMachine)
-	// cancel log when machine config change
-	fs.CancelLog(oldMachine.Category)
-	if fs.Error != nil {
+	if oldMachine.Id == constant.Zero {
+		fs.AddError(i18n.E(ErrMachineNotFound))
 		return
 	}
 	levels := make([]req.FsmCreateEvent, len(r.Levels))
 	copy(levels, r.Levels)
+	fs.checkLevels(levels)
+	if fs.Error != nil {
+		return
+	}
 	r.Levels = make([]req.FsmCreateEvent, 0)
 	eventsJson := utils.Struct2Json(levels)
 	m := make(map[string]interface{}, 0)
 	utils.CompareDiff2SnakeKey(oldMachine, r, &m)
-	if oldMachine.EventsJson != eventsJson {
-		m["events_json"] = eventsJson
+	_, nameChanged := m["submitter_name"]
+	_, confirmChanged := m["submitter_confirm"]
+	if oldMachine.EventsJson == eventsJson && !nameChanged && !confirmChanged {
+		// events are not changed, no need to create new version
+		if len(m) > 0 {
+			fs.session.
+				Model(&Machine{}).
+				Where("id = ?", id).
+				Updates(&m)
+		}
+		rp = oldMachine
+		return
 	}
+	// save current version as history, logs in progress will finish on it
+	history := oldMachine
+	history.Id = constant.Zero
+	history.HeadId = oldMachine.Id
+	fs.session.Create(&history)
+	fs.session.
+		Model(&Event{}).
+		Where("machine_id = ?", oldMachine.Id).
+		Update("machine_id", history.Id)
+	m["events_json"] = eventsJson
+	m["version"] = oldMachine.Version + 1
 	fs.session.
 		Model(&Machine{}).
 		Where("id = ?", id).
@@ -189,21 +226,38 @@ func (fs *Fsm) SubmitLog(r req.FsmCreateLog) (rp []EventItem) {
 	var l Log
 	l.Category = uint(r.Category)
 	l.Uuid = r.Uuid
-	nextEvent := fs.getNextEvent(machine.Id, startEvent.Level)
+	nextEvent := fs.getNextEvent(machine.Id, startEvent.Level, r.SubmitterDetail)
 	if fs.Error != nil {
 		return
 	}
-	l.ProgressId = startEvent.DstId
-	l.CanApprovalRoles = nextEvent.Roles
-	l.CanApprovalUsers = nextEvent.Users
 	l.SubmitterRoleId = r.SubmitterRoleId
 	l.SubmitterUserId = r.SubmitterUserId
 	l.PrevDetail = startEvent.Dst.Name
-	l.Detail = nextEvent.Name.Name
 	l.Remark = r.Remark
+	l.SubmitterDetail = r.SubmitterDetail
 	l.CurrentEventId = startEvent.Id
+	if nextEvent.Id == constant.Zero {
+		// all levels are skipped by guard, approval is ended
+		fs.endSubmitLog(l, startEvent)
+		rp = append(rp, startEvent.Dst)
+		return
+	}
+	l.ProgressId = startEvent.DstId
+	if nextEvent.Level > startEvent.Level+1 {
+		// some levels are skipped by guard, jump to the source of next event
+		l.ProgressId = getSrcItem(nextEvent, constant.FsmSuffixApproved).Id
+	}
+	l.CanApprovalRoles = nextEvent.Roles
+	l.CanApprovalUsers = nextEvent.Users
+	l.Detail = nextEvent.Name.Name
 	l.NextEventId = nextEvent.Id
 	fs.session.Create(&l)
+	fs.scheduleTask(l, nextEvent)
+	fs.fireHook(constant.FsmHookSubmit, l, Log{}, Actor{
+		RoleId: r.SubmitterRoleId,
+		UserId: r.SubmitterUserId,
+	})
+	fs.notify(constant.FsmNotifyReach, nextEvent.Id, l, r.SubmitterUserId, "")
 
 	rp = append(rp, []EventItem{
 		startEvent.Dst,
@@ -212,12 +266,41 @@ func (fs *Fsm) SubmitLog(r req.FsmCreateLog) (rp []EventItem) {
 	return
 }
 
-// ApproveLog start approve log
-func (fs *Fsm) ApproveLog(r req.FsmApproveLog) (rp resp.FsmApprovalLog) {
+// create ended log when no level matched the submitter detail
+func (fs *Fsm) endSubmitLog(l Log, startEvent Event) {
+	l.ProgressId = startEvent.DstId
+	l.Approved = constant.FsmLogStatusApproved
+	l.Detail = i18n.T(constant.FsmMsgEnded)
+	fs.session.Create(&l)
+	actor := Actor{
+		RoleId: l.SubmitterRoleId,
+		UserId: l.SubmitterUserId,
+	}
+	fs.fireHook(constant.FsmHookSubmit, l, Log{}, actor)
+	fs.fireHook(constant.FsmHookEnd, l, Log{}, actor)
 	if fs.Error != nil {
 		return
 	}
-	machine := fs.GetMachineByCategory(uint(r.Category))
+	// status transition
+	if fs.ops.transition == nil {
+		log.WithContext(fs.ops.ctx).Warn("%s", i18n.T(ErrTransitionEmpty))
+		return
+	}
+	fs.AddError(fs.ops.transition(fs.ops.ctx, resp.FsmApprovalLog{
+		Uuid:     l.Uuid,
+		Category: l.Category,
+		End:      constant.One,
+	}))
+	return
+}
+
+// ApproveLog start approve log
+func (fs *Fsm) ApproveLog(r req.FsmApproveLog) (rp resp.FsmApprovalLog) {
+	return fs.approveLog(r, false)
+}
+
+// approveLog system=true means approved by system when timeout, permission check will be skipped
+func (fs *Fsm) approveLog(r req.FsmApproveLog, system bool) (rp resp.FsmApprovalLog) {
 	if fs.Error != nil {
 		return
 	}
@@ -226,14 +309,27 @@ func (fs *Fsm) ApproveLog(r req.FsmApproveLog) (rp resp.FsmApprovalLog) {
 		Uuid:     r.Uuid,
 		Category: uint(r.Category),
 	}
-	// check current user/role permission
-	oldLog := fs.CheckLogPermission(req.FsmPermissionLog{
-		Category:       r.Category,
-		Uuid:           r.Uuid,
-		ApprovalRoleId: r.ApprovalRoleId,
-		ApprovalUserId: r.ApprovalUserId,
-		Approved:       approved,
-	})
+	var oldLog Log
+	if system {
+		// system approve when timeout, no need to check permission
+		oldLog = fs.getLastPendingLog(req.FsmLog{
+			Category: r.Category,
+			Uuid:     r.Uuid,
+		})
+		if oldLog.Id == constant.Zero {
+			fs.AddError(i18n.E(ErrNoPermissionOrEnded))
+			return
+		}
+	} else {
+		// check current user/role permission
+		oldLog = fs.CheckLogPermission(req.FsmPermissionLog{
+			Category:       r.Category,
+			Uuid:           r.Uuid,
+			ApprovalRoleId: r.ApprovalRoleId,
+			ApprovalUserId: r.ApprovalUserId,
+			Approved:       approved,
+		})
+	}
 	if fs.Error != nil {
 		return
 	}
@@ -252,10 +348,153 @@ func (fs *Fsm) ApproveLog(r req.FsmApproveLog) (rp resp.FsmApprovalLog) {
 			Model(&Log{}).
 			Where("id = ?", oldLog.Id).
 			Updates(&m)
+		fs.removeTask(oldLog.Id)
+		oldLog.Approved = constant.FsmLogStatusCancelled
+		oldLog.ApprovalOpinion = r.ApprovalOpinion
+		fs.fireHook(constant.FsmHookCancel, oldLog, Log{}, Actor{
+			RoleId: r.ApprovalRoleId,
+			UserId: r.ApprovalUserId,
+		})
 		return
 	}
 
-	desc := fs.findEventDesc(machine.Id)
+	// changed detail must be in edit fields
+	if !system && r.SubmitterDetail != "" && r.SubmitterDetail != oldLog.SubmitterDetail {
+		fs.CheckEditLogDetailPermission(req.FsmCheckEditLogDetailPermission{
+			Category:       r.Category,
+			Uuid:           r.Uuid,
+			Approver:       oldLog.Resubmit == constant.Zero && oldLog.Confirm == constant.Zero,
+			ApprovalRoleId: r.ApprovalRoleId,
+			ApprovalUserId: r.ApprovalUserId,
+			Detail:         r.SubmitterDetail,
+		})
+		if fs.Error != nil {
+			return
+		}
+	}
+
+	// log always uses the machine version it started with
+	machineId := oldLog.CurrentEvent.MachineId
+
+	// submitter withdraw, back to submitter resubmit
+	if approved == constant.FsmLogStatusWithdrawn {
+		startEvent := fs.getStartEvent(machineId)
+		var newLog Log
+		newLog.Category = oldLog.Category
+		newLog.Uuid = oldLog.Uuid
+		newLog.ProgressId = getSrcItem(startEvent, constant.FsmSuffixRefused).Id
+		newLog.SubmitterRoleId = oldLog.SubmitterRoleId
+		newLog.SubmitterUserId = oldLog.SubmitterUserId
+		newLog.PrevDetail = i18n.T(constant.FsmMsgWithdraw)
+		newLog.Detail = startEvent.Name.Name
+		newLog.Remark = oldLog.Remark
+		newLog.SubmitterDetail = oldLog.SubmitterDetail
+		newLog.CurrentEventId = startEvent.Id
+		newLog.NextEventId = startEvent.Id
+		newLog.Resubmit = constant.One
+		newLog.CanApprovalRoles = []Role{
+			{
+				Id: oldLog.SubmitterRoleId,
+			},
+		}
+		newLog.CanApprovalUsers = []User{
+			{
+				Id: oldLog.SubmitterUserId,
+			},
+		}
+		fs.session.Create(&newLog)
+		m := make(map[string]interface{}, 0)
+		m["approved"] = constant.FsmLogStatusWithdrawn
+		m["approval_role_id"] = r.ApprovalRoleId
+		m["approval_user_id"] = r.ApprovalUserId
+		m["approval_opinion"] = r.ApprovalOpinion
+		fs.session.
+			Model(&Log{}).
+			Where("id = ?", oldLog.Id).
+			Updates(&m)
+		fs.removeTask(oldLog.Id)
+		oldLog.Approved = constant.FsmLogStatusWithdrawn
+		oldLog.ApprovalRoleId = r.ApprovalRoleId
+		oldLog.ApprovalUserId = r.ApprovalUserId
+		oldLog.ApprovalOpinion = r.ApprovalOpinion
+		fs.fireHook(constant.FsmHookWithdraw, oldLog, newLog, Actor{
+			RoleId: r.ApprovalRoleId,
+			UserId: r.ApprovalUserId,
+		})
+		if fs.Error != nil {
+			return
+		}
+		rp.Resubmit = constant.One
+		rp.Withdraw = constant.One
+		if fs.ops.transition == nil {
+			log.WithContext(fs.ops.ctx).Warn("%s", i18n.T(ErrTransitionEmpty))
+			return
+		}
+		fs.AddError(fs.ops.transition(fs.ops.ctx, rp))
+		return
+	}
+
+	// countersign level: save approver vote, keep current progress until quorum reached
+	if oldLog.NextEvent.Countersign == constant.One && !system {
+		vote := LogVote{
+			LogId:           oldLog.Id,
+			EventId:         oldLog.NextEventId,
+			ApprovalRoleId:  r.ApprovalRoleId,
+			ApprovalUserId:  r.ApprovalUserId,
+			Approved:        approved,
+			ApprovalOpinion: r.ApprovalOpinion,
+			DelegatorRoleId: oldLog.DelegatorRoleId,
+			DelegatorUserId: oldLog.DelegatorUserId,
+		}
+		fs.session.Create(&vote)
+		// any refused vote will refuse the whole level
+		if approved == constant.FsmLogStatusApproved {
+			oldLog.Votes = append(oldLog.Votes, vote)
+			voted, quorum := getCountersignProgress(oldLog)
+			if voted < quorum {
+				rp.Waiting = constant.One
+				return
+			}
+		}
+	}
+
+	// add sign step before approver: back to the approvers who added sign, keep current progress
+	if oldLog.AddSign == constant.FsmAddSignBefore && approved == constant.FsmLogStatusApproved {
+		var signLog Log
+		fs.session.
+			Model(&Log{}).
+			Preload("CanApprovalRoles").
+			Preload("CanApprovalUsers").
+			Where("id = ?", oldLog.SignLogId).
+			First(&signLog)
+		newLog := newSignLog(oldLog)
+		newLog.PrevDetail = oldLog.Detail
+		newLog.AddSign = signLog.AddSign
+		newLog.SignLogId = signLog.SignLogId
+		newLog.CanApprovalRoles = signLog.CanApprovalRoles
+		newLog.CanApprovalUsers = signLog.CanApprovalUsers
+		fs.session.Create(&newLog)
+		fs.scheduleTask(newLog, oldLog.NextEvent)
+		m := make(map[string]interface{}, 0)
+		m["approved"] = constant.FsmLogStatusApproved
+		m["approval_role_id"] = r.ApprovalRoleId
+		m["approval_user_id"] = r.ApprovalUserId
+		m["approval_opinion"] = r.ApprovalOpinion
+		if system {
+			m["system_approved"] = constant.One
+		}
+		m["delegator_role_id"] = oldLog.DelegatorRoleId
+		m["delegator_user_id"] = oldLog.DelegatorUserId
+		fs.session.
+			Model(&Log{}).
+			Where("id = ?", oldLog.Id).
+			Updates(&m)
+		fs.removeTask(oldLog.Id)
+		rp.Waiting = constant.One
+		return
+	}
+
+	desc := fs.findEventDesc(machineId)
 	if fs.Error != nil {
 		return
 	}
@@ -288,7 +527,7 @@ func (fs *Fsm) ApproveLog(r req.FsmApproveLog) (rp resp.FsmApprovalLog) {
 	}
 	nextName := getNextItemName(approved, eventName)
 	f.SetState(nextName)
-	event := fs.getEvent(machine.Id, eventName)
+	event := fs.getEvent(machineId, eventName)
 	if fs.Error != nil {
 		return
 	}
@@ -304,18 +543,35 @@ func (fs *Fsm) ApproveLog(r req.FsmApproveLog) (rp resp.FsmApprovalLog) {
 	newLog.SubmitterUserId = oldLog.SubmitterUserId
 	newLog.PrevDetail = nextName
 	newLog.Remark = oldLog.Remark
+	newLog.SubmitterDetail = oldLog.SubmitterDetail
+	if r.SubmitterDetail != "" {
+		newLog.SubmitterDetail = r.SubmitterDetail
+		if diff := diffDetail(oldLog.SubmitterDetail, newLog.SubmitterDetail); len(diff) > 0 {
+			newLog.DetailDiff = utils.Struct2Json(diff)
+		}
+	}
 	newLog.CurrentEventId = event.Id
+	var nextEvent Event
 	if len(f.AvailableTransitions()) != 0 {
-		// bind next approver
-		var nextEvent Event
+		// bind next approver(the rest levels may be all skipped by guard)
 		if approved == constant.FsmLogStatusApproved {
-			nextEvent = fs.getNextEvent(machine.Id, event.Level)
+			nextEvent = fs.getNextEvent(machineId, event.Level, newLog.SubmitterDetail)
+		} else if r.RejectLevel != nil {
+			// refuse back to the level chosen by approver
+			if *r.RejectLevel < event.Level {
+				nextEvent = fs.getPrevEvent(machineId, *r.RejectLevel+1, newLog.SubmitterDetail)
+			}
+			if fs.Error == nil && (nextEvent.Id == constant.Zero || nextEvent.Level != *r.RejectLevel) {
+				fs.AddError(errors.Wrap(i18n.E(ErrIllegalRejectLevel), "rejectLevel"))
+			}
 		} else {
-			nextEvent = fs.getPrevEvent(machine.Id, event.Level)
+			nextEvent = fs.getPrevEvent(machineId, event.Level, newLog.SubmitterDetail)
 		}
 		if fs.Error != nil {
 			return
 		}
+	}
+	if nextEvent.Id > constant.Zero {
 		// no users/roles, maybe submitter resubmit/confirm
 		noUser := false
 		if len(nextEvent.Roles) == 0 && len(nextEvent.Users) == 0 {
@@ -327,6 +583,12 @@ func (fs *Fsm) ApproveLog(r req.FsmApproveLog) (rp resp.FsmApprovalLog) {
 			}
 		}
 		newLog.ProgressId = progressItem.Id
+		if approved == constant.FsmLogStatusApproved && nextEvent.Level > event.Level+1 {
+			// some levels are skipped by guard, jump to the source of next event
+			newLog.ProgressId = getSrcItem(nextEvent, constant.FsmSuffixApproved).Id
+		} else if approved == constant.FsmLogStatusRefused && nextEvent.Level+1 < event.Level {
+			newLog.ProgressId = getSrcItem(nextEvent, constant.FsmSuffixRefused).Id
+		}
 		newLog.NextEventId = nextEvent.Id
 		if rp.Resubmit == constant.One {
 			newLog.Resubmit = constant.One
@@ -356,6 +618,9 @@ func (fs *Fsm) ApproveLog(r req.FsmApproveLog) (rp resp.FsmApprovalLog) {
 		newLog.Detail = i18n.T(constant.FsmMsgEnded)
 	}
 	fs.session.Create(&newLog)
+	if rp.End == constant.Zero {
+		fs.scheduleTask(newLog, nextEvent)
+	}
 	m := make(map[string]interface{}, 0)
 	m["approved"] = constant.FsmLogStatusApproved
 	if approved == constant.FsmLogStatusRefused {
@@ -364,11 +629,51 @@ func (fs *Fsm) ApproveLog(r req.FsmApproveLog) (rp resp.FsmApprovalLog) {
 	m["approval_role_id"] = r.ApprovalRoleId
 	m["approval_user_id"] = r.ApprovalUserId
 	m["approval_opinion"] = r.ApprovalOpinion
+	if system {
+		m["system_approved"] = constant.One
+	}
+	m["delegator_role_id"] = oldLog.DelegatorRoleId
+	m["delegator_user_id"] = oldLog.DelegatorUserId
+	if approved == constant.FsmLogStatusRefused && r.RejectLevel != nil {
+		m["reject_to"] = nextEvent.Name.Name
+		oldLog.RejectTo = nextEvent.Name.Name
+	}
 	// update oldLog approved
 	fs.session.
 		Model(&Log{}).
 		Where("id = ?", oldLog.Id).
 		Updates(&m)
+	fs.removeTask(oldLog.Id)
+	oldLog.Approved = approved
+	oldLog.ApprovalRoleId = r.ApprovalRoleId
+	oldLog.ApprovalUserId = r.ApprovalUserId
+	oldLog.ApprovalOpinion = r.ApprovalOpinion
+	actor := Actor{
+		RoleId: r.ApprovalRoleId,
+		UserId: r.ApprovalUserId,
+		System: system,
+	}
+	hook := constant.FsmHookLevelApproved
+	if approved == constant.FsmLogStatusRefused {
+		hook = constant.FsmHookRejected
+	} else if oldLog.Resubmit == constant.One {
+		hook = constant.FsmHookResubmit
+	}
+	fs.fireHook(hook, oldLog, newLog, actor)
+	if rp.End == constant.One {
+		fs.fireHook(constant.FsmHookEnd, oldLog, newLog, actor)
+	}
+	if approved == constant.FsmLogStatusRefused {
+		fs.notify(constant.FsmNotifyReject, oldLog.NextEventId, oldLog, r.ApprovalUserId, r.ApprovalOpinion)
+	} else {
+		fs.notify(constant.FsmNotifyPass, oldLog.NextEventId, oldLog, r.ApprovalUserId, r.ApprovalOpinion)
+	}
+	if rp.End == constant.Zero {
+		fs.notify(constant.FsmNotifyReach, nextEvent.Id, newLog, r.ApprovalUserId, r.ApprovalOpinion)
+	}
+	if fs.Error != nil {
+		return
+	}
 	// status transition
 	if fs.ops.transition == nil {
 		log.WithContext(fs.ops.ctx).Warn("%s", i18n.T(ErrTransitionEmpty))
@@ -400,8 +705,18 @@ func (fs *Fsm) CancelLog(category uint) {
 			Category: oldLogs[i].Category,
 			Cancel:   constant.One,
 		})
+		fs.removeTask(oldLogs[i].Id)
 	}
 	q.Updates(&m)
+	for _, item := range oldLogs {
+		item.Approved = constant.FsmLogStatusCancelled
+		fs.fireHook(constant.FsmHookCancel, item, Log{}, Actor{
+			System: true,
+		})
+	}
+	if fs.Error != nil {
+		return
+	}
 	if fs.ops.transition == nil {
 		log.WithContext(fs.ops.ctx).Warn("%s", i18n.T(ErrTransitionEmpty))
 		return
@@ -442,8 +757,21 @@ func (fs *Fsm) CancelLogByUuids(r req.FsmCancelLog) {
 			Category: oldLogs[i].Category,
 			Cancel:   constant.One,
 		})
+		fs.removeTask(oldLogs[i].Id)
 	}
 	q.Updates(&m)
+	for _, item := range oldLogs {
+		item.Approved = constant.FsmLogStatusCancelled
+		item.ApprovalRoleId = r.ApprovalRoleId
+		item.ApprovalUserId = r.ApprovalUserId
+		fs.fireHook(constant.FsmHookCancel, item, Log{}, Actor{
+			RoleId: r.ApprovalRoleId,
+			UserId: r.ApprovalUserId,
+		})
+	}
+	if fs.Error != nil {
+		return
+	}
 	// status transition
 	if fs.ops.transition == nil {
 		log.WithContext(fs.ops.ctx).Warn("%s", i18n.T(ErrTransitionEmpty))
@@ -453,6 +781,295 @@ func (fs *Fsm) CancelLogByUuids(r req.FsmCancelLog) {
 	return
 }
 
+// BatchApproveLog approve/refuse logs one by one with the same opinion, each log runs in its own nested transaction(savepoint), failures will not roll back the successful ones
+func (fs *Fsm) BatchApproveLog(r req.FsmBatchApproveLog) (rp []resp.FsmBatchApprovalLog) {
+	rp = make([]resp.FsmBatchApprovalLog, 0)
+	if fs.Error != nil {
+		return
+	}
+	approved := uint(r.Approved)
+	if approved != constant.FsmLogStatusApproved && approved != constant.FsmLogStatusRefused {
+		fs.AddError(errors.Wrap(i18n.E(ErrParams), "approved"))
+		return
+	}
+	if len(r.Uuids) == 0 {
+		fs.AddError(errors.Wrap(i18n.E(ErrParams), "uuids"))
+		return
+	}
+	for _, uuid := range r.Uuids {
+		var item resp.FsmBatchApprovalLog
+		var sub Fsm
+		err := fs.session.Transaction(func(tx *gorm.DB) error {
+			sub = Fsm{
+				ops:     fs.ops,
+				session: tx,
+			}
+			item.FsmApprovalLog = sub.ApproveLog(req.FsmApproveLog{
+				Category:        r.Category,
+				Uuid:            uuid,
+				ApprovalRoleId:  r.ApprovalRoleId,
+				ApprovalUserId:  r.ApprovalUserId,
+				ApprovalOpinion: r.ApprovalOpinion,
+				Approved:        r.Approved,
+			})
+			return sub.Error
+		})
+		item.Uuid = uuid
+		item.Category = uint(r.Category)
+		if err != nil {
+			item.Error = err.Error()
+		} else {
+			item.Success = constant.One
+			// side effects of rolled back items are dropped with sub
+			fs.afterCommit = append(fs.afterCommit, sub.afterCommit...)
+		}
+		rp = append(rp, item)
+	}
+	return
+}
+
+// AddSignLog current approver inserts ad-hoc approvers before/after himself, only the log is changed(machine levels keep the same)
+func (fs *Fsm) AddSignLog(r req.FsmAddSignLog) {
+	if fs.Error != nil {
+		return
+	}
+	position := uint(r.Position)
+	if position != constant.FsmAddSignBefore && position != constant.FsmAddSignAfter {
+		fs.AddError(errors.Wrap(i18n.E(ErrParams), "position"))
+		return
+	}
+	userIds := r.Users.Uints()
+	if len(userIds) == 0 {
+		fs.AddError(errors.Wrap(i18n.E(ErrParams), "users"))
+		return
+	}
+	// only current approver can add sign
+	oldLog := fs.CheckLogPermission(req.FsmPermissionLog{
+		Category:       r.Category,
+		Uuid:           r.Uuid,
+		ApprovalRoleId: r.ApprovalRoleId,
+		ApprovalUserId: r.ApprovalUserId,
+		Approved:       constant.FsmLogStatusApproved,
+	})
+	if fs.Error != nil {
+		return
+	}
+	if oldLog.Resubmit == constant.One || oldLog.Confirm == constant.One {
+		fs.AddError(i18n.E(ErrNoPermissionApprove))
+		return
+	}
+	if oldLog.NextEvent.Countersign == constant.One {
+		fs.AddError(i18n.E(ErrCountersignAddSign))
+		return
+	}
+	newLog := newSignLog(oldLog)
+	newLog.PrevDetail = i18n.T(constant.FsmMsgAddSign)
+	newLog.AddSign = position
+	newLog.SignLogId = oldLog.Id
+	newLog.CanApprovalRoles = []Role{}
+	newLog.CanApprovalUsers = fs.findUser(userIds)
+	fs.session.Create(&newLog)
+	fs.scheduleTask(newLog, oldLog.NextEvent)
+	m := make(map[string]interface{}, 0)
+	// approver who added sign after himself is approved
+	m["approved"] = constant.FsmLogStatusApproved
+	if position == constant.FsmAddSignBefore {
+		m["approved"] = constant.FsmLogStatusAddSign
+	}
+	m["approval_role_id"] = r.ApprovalRoleId
+	m["approval_user_id"] = r.ApprovalUserId
+	m["approval_opinion"] = r.ApprovalOpinion
+	m["delegator_role_id"] = oldLog.DelegatorRoleId
+	m["delegator_user_id"] = oldLog.DelegatorUserId
+	fs.session.
+		Model(&Log{}).
+		Where("id = ?", oldLog.Id).
+		Updates(&m)
+	fs.removeTask(oldLog.Id)
+	return
+}
+
+// ProcessTask process remind/timeout task scheduled by delay queue, match=false if it's not a fsm task
+func (fs *Fsm) ProcessTask(t delay.Task) (match bool) {
+	if fs.Error != nil {
+		return
+	}
+	var p taskPayload
+	utils.Json2Struct(t.Payload, &p)
+	if strings.HasPrefix(t.Name, constant.FsmTaskRemind) {
+		match = true
+		fs.RemindLog(p.LogId)
+	} else if strings.HasPrefix(t.Name, constant.FsmTaskTimeout) {
+		match = true
+		fs.TimeoutLog(p.LogId)
+	}
+	return
+}
+
+// RemindLog remind approvers of pending log
+func (fs *Fsm) RemindLog(logId uint) {
+	if fs.Error != nil {
+		return
+	}
+	l := fs.getPendingLogById(logId)
+	// approval is ended
+	if l.Id == constant.Zero || fs.ops.remind == nil {
+		return
+	}
+	fs.AddError(fs.ops.remind(fs.ops.ctx, l))
+	return
+}
+
+// TimeoutLog handle pending log by timeout action of current level
+func (fs *Fsm) TimeoutLog(logId uint) {
+	if fs.Error != nil {
+		return
+	}
+	l := fs.getPendingLogById(logId)
+	// approval is ended
+	if l.Id == constant.Zero {
+		return
+	}
+	switch l.NextEvent.TimeoutAction {
+	case constant.FsmTimeoutActionEscalate:
+		fs.escalateLog(l)
+	case constant.FsmTimeoutActionApprove, constant.FsmTimeoutActionRefuse:
+		approved := constant.FsmLogStatusApproved
+		if l.NextEvent.TimeoutAction == constant.FsmTimeoutActionRefuse {
+			approved = constant.FsmLogStatusRefused
+		}
+		fs.approveLog(req.FsmApproveLog{
+			Category:        req.NullUint(l.Category),
+			Uuid:            l.Uuid,
+			ApprovalOpinion: i18n.T(constant.FsmMsgTimeout),
+			Approved:        req.NullUint(approved),
+		}, true)
+	}
+	return
+}
+
+// replace approvers of pending log with fallback role, then remind and notify them
+func (fs *Fsm) escalateLog(l Log) {
+	// findRole creates missing role, the fallback role must be saved by machine
+	roles := make([]Role, 0)
+	fs.session.
+		Model(&Role{}).
+		Where("id = ?", l.NextEvent.FallbackRoleId).
+		Find(&roles)
+	if l.NextEvent.FallbackRoleId == constant.Zero || len(roles) == 0 {
+		fs.AddError(errors.Wrap(i18n.E(ErrParams), "fallbackRoleId"))
+		return
+	}
+	var sub Fsm
+	err := fs.session.Transaction(func(tx *gorm.DB) error {
+		sub = Fsm{
+			ops:     fs.ops,
+			session: tx,
+		}
+		sub.AddError(tx.Model(&l).Association("CanApprovalRoles").Replace(roles))
+		sub.AddError(tx.Model(&l).Association("CanApprovalUsers").Clear())
+		sub.AddError(tx.
+			Model(&Log{}).
+			Where("id = ?", l.Id).
+			Update("escalated", constant.One).Error)
+		if sub.Error != nil {
+			return sub.Error
+		}
+		l.CanApprovalRoles = roles
+		l.CanApprovalUsers = nil
+		// escalate only once, fallback approvers are reminded without timeout task
+		event := l.NextEvent
+		event.Timeout = constant.Zero
+		sub.scheduleTask(l, event)
+		sub.notify(constant.FsmNotifyReach, l.NextEventId, l, constant.Zero, i18n.T(constant.FsmMsgTimeout))
+		return sub.Error
+	})
+	if fs.AddError(err) != nil {
+		return
+	}
+	fs.afterCommit = append(fs.afterCommit, sub.afterCommit...)
+}
+
+// CreateDelegation delegator delegates approval permission to proxy user
+func (fs *Fsm) CreateDelegation(r req.FsmCreateDelegation) {
+	if fs.Error != nil {
+		return
+	}
+	if r.FromUserId == constant.Zero || uint(r.ToUserId) == constant.Zero || r.FromUserId == uint(r.ToUserId) {
+		fs.AddError(errors.Wrap(i18n.E(ErrParams), "toUserId"))
+		return
+	}
+	if r.StartAt.IsZero() || r.EndAt.IsZero() || !r.EndAt.Gt(r.StartAt.Carbon) {
+		fs.AddError(errors.Wrap(i18n.E(ErrParams), "endAt"))
+		return
+	}
+	if uint(r.Role) != constant.One {
+		// only delegate approvals of delegator himself
+		r.FromRoleId = constant.Zero
+	} else if r.FromRoleId == constant.Zero {
+		fs.AddError(errors.Wrap(i18n.E(ErrParams), "fromRoleId"))
+		return
+	}
+	var delegation Delegation
+	utils.Struct2StructByJson(r, &delegation)
+	fs.session.Create(&delegation)
+	return
+}
+
+// FindDelegation find delegations of delegator or proxy user
+func (fs *Fsm) FindDelegation(r *req.FsmDelegation) (rp []resp.FsmDelegation) {
+	rp = make([]resp.FsmDelegation, 0)
+	if fs.Error != nil {
+		return
+	}
+	list := make([]Delegation, 0)
+	q := fs.session.
+		Model(&Delegation{}).
+		Order("id DESC")
+	if r.UserId > constant.Zero {
+		q.Where("from_user_id = ? OR to_user_id = ?", r.UserId, r.UserId)
+	}
+	page := &r.Page
+	countCache := false
+	if page.CountCache != nil {
+		countCache = *page.CountCache
+	}
+	if !page.NoPagination {
+		if !page.SkipCount {
+			q.Count(&page.Total)
+		}
+		if page.Total > 0 || page.SkipCount {
+			limit, offset := page.GetLimit()
+			q.Limit(limit).Offset(offset).Find(&list)
+		}
+	} else {
+		// no pagination
+		q.Find(&list)
+		page.Total = int64(len(list))
+		page.GetLimit()
+	}
+	page.CountCache = &countCache
+	utils.Struct2StructByJson(list, &rp)
+	return
+}
+
+// DeleteDelegationByIds delete delegations of delegator(fromUserId=0 means any delegator, only for admin)
+func (fs *Fsm) DeleteDelegationByIds(ids []uint, fromUserId uint) {
+	if fs.Error != nil {
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+	q := fs.session.
+		Where("id IN (?)", ids)
+	if fromUserId > constant.Zero {
+		q.Where("from_user_id = ?", fromUserId)
+	}
+	q.Delete(&Delegation{})
+	return
+}
+
 // CheckLogPermission
 // =======================================================
 // query function
@@ -484,6 +1101,32 @@ func (fs *Fsm) CheckLogPermission(r req.FsmPermissionLog) (rp Log) {
 			return
 		}
 	}
+	if r.Approved == constant.FsmLogStatusWithdrawn {
+		if last.SubmitterRoleId != r.ApprovalRoleId && last.SubmitterUserId != r.ApprovalUserId {
+			fs.AddError(i18n.E(ErrOnlySubmitterWithdraw))
+			return
+		}
+		// any level approved/countersign voted, or it is waiting submitter already
+		if last.CurrentEvent.Level > constant.Zero || len(last.Votes) > 0 || last.Resubmit == constant.One || last.Confirm == constant.One {
+			fs.AddError(i18n.E(ErrActedCannotWithdraw))
+			return
+		}
+		// previous step must be submitter resubmit(add sign steps are created by approver)
+		var prev Log
+		fs.session.
+			Model(&Log{}).
+			Where("category = ?", last.Category).
+			Where("uuid = ?", last.Uuid).
+			Where("id < ?", last.Id).
+			Order("id DESC").
+			First(&prev)
+		if prev.Id > constant.Zero && prev.Resubmit == constant.Zero {
+			fs.AddError(i18n.E(ErrActedCannotWithdraw))
+			return
+		}
+		rp = last
+		return
+	}
 	roles := make([]uint, 0)
 	users := make([]uint, 0)
 	for _, role := range last.CanApprovalRoles {
@@ -492,9 +1135,44 @@ func (fs *Fsm) CheckLogPermission(r req.FsmPermissionLog) (rp Log) {
 	for _, user := range last.CanApprovalUsers {
 		users = append(users, user.Id)
 	}
+	userId := r.ApprovalUserId
+	roleId := r.ApprovalRoleId
 	if !utils.Contains(roles, r.ApprovalRoleId) && !utils.Contains(users, r.ApprovalUserId) {
-		fs.AddError(i18n.E(ErrNoPermissionApprove))
-		return
+		// approve on behalf of delegator
+		delegated := false
+		for _, item := range fs.findActiveDelegation(r.ApprovalUserId) {
+			if !matchDelegationCategory(item, uint(r.Category)) {
+				continue
+			}
+			if utils.ContainsUint(users, item.FromUserId) {
+				last.DelegatorUserId = item.FromUserId
+				delegated = true
+			}
+			if item.FromRoleId > constant.Zero && utils.ContainsUint(roles, item.FromRoleId) {
+				last.DelegatorRoleId = item.FromRoleId
+				delegated = true
+			}
+			if delegated {
+				userId = last.DelegatorUserId
+				roleId = last.DelegatorRoleId
+				break
+			}
+		}
+		if !delegated {
+			fs.AddError(i18n.E(ErrNoPermissionApprove))
+			return
+		}
+	}
+	// countersign approver can only vote once
+	if last.NextEvent.Countersign == constant.One {
+		for _, vote := range last.Votes {
+			voteUserId, voteRoleId := vote.approver()
+			if (userId > constant.Zero && voteUserId == userId) ||
+				(userId == constant.Zero && voteRoleId == roleId) {
+				fs.AddError(i18n.E(ErrRepeatApprove))
+				return
+			}
+		}
 	}
 	rp = last
 	return
@@ -502,11 +1180,31 @@ func (fs *Fsm) CheckLogPermission(r req.FsmPermissionLog) (rp Log) {
 
 // CheckEditLogDetailPermission check verify whether the current user/role has permission to edit log detail
 func (fs *Fsm) CheckEditLogDetailPermission(r req.FsmCheckEditLogDetailPermission) {
+	fs.checkEditLogDetailPermission(r)
+	return
+}
+
+// EditLogDetail check edit log detail permission and save detail diff to the pending log
+func (fs *Fsm) EditLogDetail(r req.FsmCheckEditLogDetailPermission) {
+	last, diff := fs.checkEditLogDetailPermission(r)
+	if fs.Error != nil || len(diff) == 0 {
+		return
+	}
+	// the same log may be edited many times before approve
+	diff = append(getDetailDiff(last.EditDiff), diff...)
+	fs.session.
+		Model(&Log{}).
+		Where("id = ?", last.Id).
+		Update("edit_diff", utils.Struct2Json(diff))
+	return
+}
+
+func (fs *Fsm) checkEditLogDetailPermission(r req.FsmCheckEditLogDetailPermission) (last Log, diff []resp.FsmDetailDiff) {
 	if fs.Error != nil {
 		return
 	}
 	// check whether approval is pending
-	last := fs.getLastPendingLog(req.FsmLog{
+	last = fs.getLastPendingLog(req.FsmLog{
 		Category: r.Category,
 		Uuid:     r.Uuid,
 	})
@@ -514,6 +1212,13 @@ func (fs *Fsm) CheckEditLogDetailPermission(r req.FsmCheckEditLogDetailPermissio
 		fs.AddError(i18n.E(ErrNoPermissionEdit))
 		return
 	}
+	if r.Detail != "" {
+		oldDetail := r.OldDetail
+		if oldDetail == "" {
+			oldDetail = last.SubmitterDetail
+		}
+		diff = diffDetail(oldDetail, r.Detail)
+	}
 	submitter := false
 	confirm := false
 	if last.SubmitterRoleId == r.ApprovalRoleId && last.SubmitterUserId == r.ApprovalUserId {
@@ -522,21 +1227,23 @@ func (fs *Fsm) CheckEditLogDetailPermission(r req.FsmCheckEditLogDetailPermissio
 	if r.Approver {
 		submitter = false
 	}
-	if submitter && last.NextEventId == 0 {
+	if submitter && (last.Confirm == constant.One || last.NextEventId == 0) {
 		confirm = true
 	}
 	edit := false
 	editFields := ""
 	if submitter || confirm {
-		machine := fs.GetMachineByCategory(uint(r.Category))
-		if fs.Error != nil {
-			return
-		}
+		// machine version of the log
+		var machine Machine
+		fs.session.
+			Model(&Machine{}).
+			Where("id = ?", last.CurrentEvent.MachineId).
+			First(&machine)
 		edit = true
-		if submitter {
-			editFields = machine.SubmitterEditFields
-		} else {
+		if confirm {
 			editFields = machine.SubmitterConfirmEditFields
+		} else {
+			editFields = machine.SubmitterEditFields
 		}
 	} else {
 		userIds := make([]uint, 0)
@@ -561,14 +1268,20 @@ func (fs *Fsm) CheckEditLogDetailPermission(r req.FsmCheckEditLogDetailPermissio
 		fs.AddError(i18n.E(ErrNoPermissionEdit))
 		return
 	}
-	// split permission fields
-	fields := strings.Split(utils.SnakeCase(editFields), ",")
-	if len(fields) > 0 {
-		for _, f := range r.Fields {
-			if !utils.Contains(fields, utils.SnakeCase(f)) {
-				fs.AddError(errors.Wrap(i18n.E(ErrNoPermissionEdit), f))
-				return
-			}
+	fields := splitEditFields(editFields)
+	if len(fields) == 0 {
+		return
+	}
+	paths := make([]string, 0)
+	paths = append(paths, r.Fields...)
+	// nested json paths changed by new detail
+	for _, item := range diff {
+		paths = append(paths, item.Path)
+	}
+	for _, f := range paths {
+		if !matchEditField(fields, f) {
+			fs.AddError(errors.Wrap(i18n.E(ErrNoPermissionEdit), f))
+			return
 		}
 	}
 	return
@@ -582,17 +1295,115 @@ func (fs *Fsm) GetMachineByCategory(category uint) (machine Machine) {
 	fs.session.
 		Model(&Machine{}).
 		Where("category = ?", category).
+		Where("head_id = ?", constant.Zero).
 		First(&machine)
 	return
 }
 
+// FindMachineVersion find all versions of machine
+func (fs *Fsm) FindMachineVersion(id uint) (rp []resp.FsmMachine) {
+	rp = make([]resp.FsmMachine, 0)
+	if fs.Error != nil {
+		return
+	}
+	list := make([]Machine, 0)
+	fs.session.
+		Model(&Machine{}).
+		Where("id = ? OR head_id = ?", id, id).
+		Order("version DESC").
+		Find(&list)
+	utils.Struct2StructByJson(list, &rp)
+	return
+}
+
+// DiffMachineVersion compare machine fields and levels of two versions
+func (fs *Fsm) DiffMachineVersion(r req.FsmMachineVersionDiff) (rp resp.FsmMachineVersionDiff) {
+	if fs.Error != nil {
+		return
+	}
+	from := fs.getMachineByVersion(r.Id, uint(r.From))
+	to := fs.getMachineByVersion(r.Id, uint(r.To))
+	if from.Id == constant.Zero || to.Id == constant.Zero {
+		fs.AddError(i18n.E(ErrMachineNotFound))
+		return
+	}
+	rp.From = from.Version
+	rp.To = to.Version
+	// machine fields
+	var fromMachine, toMachine req.FsmCreateMachine
+	utils.Struct2StructByJson(from, &fromMachine)
+	utils.Struct2StructByJson(to, &toMachine)
+	fromMachine.Levels = nil
+	toMachine.Levels = nil
+	rp.Machine = make(map[string]interface{})
+	utils.CompareDiff(fromMachine, toMachine, &rp.Machine)
+	// levels(level name is unique in one version)
+	fromLevels := make([]req.FsmCreateEvent, 0)
+	toLevels := make([]req.FsmCreateEvent, 0)
+	utils.Json2Struct(from.EventsJson, &fromLevels)
+	utils.Json2Struct(to.EventsJson, &toLevels)
+	rp.Levels = make([]resp.FsmLevelDiff, 0)
+	for i, item := range toLevels {
+		index := -1
+		for j, old := range fromLevels {
+			if old.Name == item.Name {
+				index = j
+				break
+			}
+		}
+		if index < 0 {
+			fields := make(map[string]interface{})
+			utils.Struct2StructByJson(item, &fields)
+			rp.Levels = append(rp.Levels, resp.FsmLevelDiff{
+				Index:  i,
+				Name:   item.Name,
+				Action: constant.FsmLevelDiffAdd,
+				Fields: fields,
+			})
+			continue
+		}
+		fields := make(map[string]interface{})
+		utils.CompareDiff(fromLevels[index], item, &fields)
+		if index != i {
+			fields["index"] = i
+		}
+		if len(fields) > 0 {
+			rp.Levels = append(rp.Levels, resp.FsmLevelDiff{
+				Index:  i,
+				Name:   item.Name,
+				Action: constant.FsmLevelDiffUpdate,
+				Fields: fields,
+			})
+		}
+	}
+	for i, old := range fromLevels {
+		exists := false
+		for _, item := range toLevels {
+			if old.Name == item.Name {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			rp.Levels = append(rp.Levels, resp.FsmLevelDiff{
+				Index:  i,
+				Name:   old.Name,
+				Action: constant.FsmLevelDiffRemove,
+			})
+		}
+	}
+	return
+}
+
 func (fs *Fsm) FindMachine(r *req.FsmMachine) (rp []resp.FsmMachine) {
 	rp = make([]resp.FsmMachine, 0)
 	if fs.Error != nil {
 		return
 	}
 	list := make([]Machine, 0)
-	q := fs.session.Model(&Machine{})
+	q := fs.session.
+		Model(&Machine{}).
+		Where("head_id = ?", constant.Zero)
 	name := strings.TrimSpace(r.Name)
 	if r.Category != nil {
 		q.Where("category = ?", *r.Category)
@@ -647,6 +1458,7 @@ func (fs *Fsm) FindLog(r req.FsmLog) (rp []Log) {
 		Preload("NextEvent.Name").
 		Preload("CanApprovalRoles").
 		Preload("CanApprovalUsers").
+		Preload("Votes").
 		Where("category = ?", r.Category).
 		Where("uuid = ?", r.Uuid).
 		Find(&rp)
@@ -669,6 +1481,7 @@ func (fs *Fsm) FindLogTrack(logs []Log) (rp []resp.FsmLogTrack) {
 		prevApproved := constant.FsmLogStatusWaiting
 		prevCancel := constant.Zero
 		prevOpinion := ""
+		var prevLog *Log
 		end := constant.Zero
 		cancel := constant.Zero
 		if item.Approved == constant.FsmLogStatusCancelled {
@@ -680,21 +1493,36 @@ func (fs *Fsm) FindLogTrack(logs []Log) (rp []resp.FsmLogTrack) {
 				prevCancel = constant.One
 			}
 			prevOpinion = logs[i-1].ApprovalOpinion
+			prevLog = &logs[i-1]
 		}
 		if i == l-1 && item.NextEventId == constant.Zero {
 			end = constant.One
 		}
+		prevTrack := resp.FsmLogTrack{
+			Time: resp.Time{
+				CreatedAt: item.CreatedAt,
+				UpdatedAt: item.UpdatedAt,
+			},
+			Name:    item.PrevDetail,
+			Opinion: prevOpinion,
+			Status:  prevApproved,
+		}
+		if prevLog != nil {
+			prevTrack.System = prevLog.SystemApproved
+			prevTrack.ApprovalRoleId = prevLog.ApprovalRoleId
+			prevTrack.ApprovalUserId = prevLog.ApprovalUserId
+			prevTrack.DelegatorRoleId = prevLog.DelegatorRoleId
+			prevTrack.DelegatorUserId = prevLog.DelegatorUserId
+			prevTrack.RejectTo = prevLog.RejectTo
+			if item.SignLogId == prevLog.Id {
+				prevTrack.AddSign = item.AddSign
+			}
+			prevTrack.Diff = append(getDetailDiff(prevLog.EditDiff), getDetailDiff(item.DetailDiff)...)
+			setCountersignTrack(*prevLog, &prevTrack)
+		}
 		if end == constant.One || cancel == constant.One {
-			rp = append(rp, resp.FsmLogTrack{
-				Time: resp.Time{
-					CreatedAt: item.CreatedAt,
-					UpdatedAt: item.UpdatedAt,
-				},
-				Name:    item.PrevDetail,
-				Opinion: prevOpinion,
-				Status:  prevApproved,
-				Cancel:  prevCancel,
-			}, resp.FsmLogTrack{
+			prevTrack.Cancel = prevCancel
+			rp = append(rp, prevTrack, resp.FsmLogTrack{
 				Time: resp.Time{
 					CreatedAt: item.CreatedAt,
 					UpdatedAt: item.UpdatedAt,
@@ -706,24 +1534,21 @@ func (fs *Fsm) FindLogTrack(logs []Log) (rp []resp.FsmLogTrack) {
 				Cancel:  cancel,
 			})
 		} else {
-			rp = append(rp, resp.FsmLogTrack{
-				Time: resp.Time{
-					CreatedAt: item.CreatedAt,
-					UpdatedAt: item.UpdatedAt,
-				},
-				Name:    item.PrevDetail,
-				Opinion: prevOpinion,
-				Status:  prevApproved,
-				End:     end,
-				Cancel:  cancel,
-			})
+			prevTrack.End = end
+			prevTrack.Cancel = cancel
+			rp = append(rp, prevTrack)
 		}
 		if i == l-1 && item.Approved == constant.FsmLogStatusWaiting {
-			rp = append(rp, resp.FsmLogTrack{
-				Name:     logs[i].Detail,
-				Resubmit: item.Resubmit,
-				Confirm:  item.Confirm,
-			})
+			track := resp.FsmLogTrack{
+				Name:      logs[i].Detail,
+				Resubmit:  item.Resubmit,
+				Confirm:   item.Confirm,
+				Escalated: item.Escalated,
+				AddSign:   item.AddSign,
+				Diff:      getDetailDiff(item.EditDiff),
+			}
+			setCountersignTrack(item, &track)
+			rp = append(rp, track)
 		}
 	}
 	return
@@ -747,6 +1572,40 @@ func (fs *Fsm) FindPendingLogByApprover(r *req.FsmPendingLog) (rp []resp.FsmAppr
 		Model(&LogApprovalRoleRelation{}).
 		Where("role_id = ?", r.ApprovalRoleId).
 		Pluck("log_id", &logIds2)
+	// logs of delegators
+	for _, item := range fs.findActiveDelegation(r.ApprovalUserId) {
+		delegatedIds := make([]uint, 0)
+		fs.session.
+			Model(&LogApprovalUserRelation{}).
+			Where("user_id = ?", item.FromUserId).
+			Pluck("log_id", &delegatedIds)
+		if item.FromRoleId > constant.Zero {
+			roleLogIds := make([]uint, 0)
+			fs.session.
+				Model(&LogApprovalRoleRelation{}).
+				Where("role_id = ?", item.FromRoleId).
+				Pluck("log_id", &roleLogIds)
+			delegatedIds = append(delegatedIds, roleLogIds...)
+		}
+		if item.Categories != "" && len(delegatedIds) > 0 {
+			categoryLogIds := make([]uint, 0)
+			fs.session.
+				Model(&Log{}).
+				Where("id IN (?)", delegatedIds).
+				Where("category IN (?)", utils.Str2UintArr(item.Categories)).
+				Pluck("id", &categoryLogIds)
+			delegatedIds = categoryLogIds
+		}
+		logIds2 = append(logIds2, delegatedIds...)
+	}
+	// countersign logs the approver has already voted
+	votedIds := make([]uint, 0)
+	if r.ApprovalUserId > constant.Zero {
+		fs.session.
+			Model(&LogVote{}).
+			Where("approval_user_id = ?", r.ApprovalUserId).
+			Pluck("log_id", &votedIds)
+	}
 	list := make([]Log, 0)
 	ids := append(logIds1, logIds2...)
 	if len(ids) > 0 {
@@ -756,6 +1615,9 @@ func (fs *Fsm) FindPendingLogByApprover(r *req.FsmPendingLog) (rp []resp.FsmAppr
 			Preload("CanApprovalUsers").
 			Where("approved = ?", constant.FsmLogStatusWaiting).
 			Where("id IN (?)", ids)
+		if len(votedIds) > 0 {
+			q.Where("id NOT IN (?)", votedIds)
+		}
 		if uint(r.Category) > constant.Zero {
 			q.Where("category = ?", r.Category)
 		}
@@ -797,6 +1659,7 @@ func (fs *Fsm) getLastPendingLog(r req.FsmLog) (rp Log) {
 		Preload("Progress").
 		Preload("CurrentEvent").
 		Preload("NextEvent").
+		Preload("Votes").
 		Where("category = ?", r.Category).
 		Where("uuid = ?", r.Uuid).
 		Where("approved = ?", constant.FsmLogStatusWaiting).
@@ -804,6 +1667,94 @@ func (fs *Fsm) getLastPendingLog(r req.FsmLog) (rp Log) {
 	return
 }
 
+func (fs *Fsm) getMachineByVersion(id, version uint) (rp Machine) {
+	fs.session.
+		Model(&Machine{}).
+		Where("id = ? OR head_id = ?", id, id).
+		Where("version = ?", version).
+		First(&rp)
+	return
+}
+
+// find delegations of proxy user in effect now
+func (fs *Fsm) findActiveDelegation(toUserId uint) (rp []Delegation) {
+	rp = make([]Delegation, 0)
+	if toUserId == constant.Zero {
+		return
+	}
+	now := carbon.Now().ToDateTimeString()
+	fs.session.
+		Model(&Delegation{}).
+		Where("to_user_id = ?", toUserId).
+		Where("start_at <= ?", now).
+		Where("end_at >= ?", now).
+		Find(&rp)
+	return
+}
+
+func (fs *Fsm) getPendingLogById(id uint) (rp Log) {
+	fs.session.
+		Model(&Log{}).
+		Preload("CanApprovalRoles").
+		Preload("CanApprovalUsers").
+		Preload("NextEvent").
+		Where("id = ?", id).
+		Where("approved = ?", constant.FsmLogStatusWaiting).
+		First(&rp)
+	return
+}
+
+// schedule remind/timeout task by delay queue when log is waiting for approval(after commit)
+func (fs *Fsm) scheduleTask(l Log, event Event) {
+	if fs.ops.queue == nil {
+		return
+	}
+	payload := utils.Struct2Json(taskPayload{
+		Category: l.Category,
+		Uuid:     l.Uuid,
+		LogId:    l.Id,
+	})
+	qu := fs.ops.queue
+	ctx := fs.ops.ctx
+	fs.onCommit(func() {
+		if event.Remind > constant.Zero {
+			err := qu.Once(
+				delay.WithQueueTaskUuid(getTaskUid(constant.FsmTaskRemind, l.Id)),
+				delay.WithQueueTaskName(constant.FsmTaskRemind),
+				delay.WithQueueTaskPayload(payload),
+				delay.WithQueueTaskIn(time.Duration(event.Remind)*time.Hour),
+			)
+			if err != nil {
+				log.WithContext(ctx).WithError(err).Error("schedule remind task of log %d failed", l.Id)
+			}
+		}
+		if event.Timeout > constant.Zero && event.TimeoutAction != constant.FsmTimeoutActionNone {
+			err := qu.Once(
+				delay.WithQueueTaskUuid(getTaskUid(constant.FsmTaskTimeout, l.Id)),
+				delay.WithQueueTaskName(constant.FsmTaskTimeout),
+				delay.WithQueueTaskPayload(payload),
+				delay.WithQueueTaskIn(time.Duration(event.Timeout)*time.Hour),
+			)
+			if err != nil {
+				log.WithContext(ctx).WithError(err).Error("schedule timeout task of log %d failed", l.Id)
+			}
+		}
+	})
+}
+
+// remove remind/timeout task when log is approved/cancelled(after commit)
+func (fs *Fsm) removeTask(logId uint) {
+	if fs.ops.queue == nil {
+		return
+	}
+	qu := fs.ops.queue
+	fs.onCommit(func() {
+		// task may not exist or has been processed, ignore err
+		qu.Remove(getTaskUid(constant.FsmTaskRemind, logId))
+		qu.Remove(getTaskUid(constant.FsmTaskTimeout, logId))
+	})
+}
+
 func (fs *Fsm) getEvent(machineId uint, name string) (rp Event) {
 	if fs.Error != nil {
 		return
@@ -842,7 +1793,8 @@ func (fs *Fsm) getStartEvent(machineId uint) (rp Event) {
 	return
 }
 
-func (fs *Fsm) getPrevEvent(machineId uint, level uint) (rp Event) {
+// get the nearest previous event, levels whose guard is false will be skipped
+func (fs *Fsm) getPrevEvent(machineId uint, level uint, detail string) (rp Event) {
 	events := make([]Event, 0)
 	fs.session.
 		Preload("Name").
@@ -851,11 +1803,18 @@ func (fs *Fsm) getPrevEvent(machineId uint, level uint) (rp Event) {
 		Preload("Roles").
 		Preload("Users").
 		Where("machine_id = ?", machineId).
-		Where("level = ?", level-1).
+		Where("level < ?", level).
+		Order("level DESC").
 		Order("sort").
 		Find(&events)
 	for _, event := range events {
 		if strings.HasSuffix(event.Name.Name, i18n.T(constant.FsmSuffixWaiting)) || strings.HasSuffix(event.Name.Name, i18n.T(constant.FsmSuffixResubmit)) {
+			if !fs.matchGuard(event.Guard, detail) {
+				if fs.Error != nil {
+					return
+				}
+				continue
+			}
 			rp = event
 			return
 		}
@@ -863,7 +1822,8 @@ func (fs *Fsm) getPrevEvent(machineId uint, level uint) (rp Event) {
 	return
 }
 
-func (fs *Fsm) getNextEvent(machineId uint, level uint) (rp Event) {
+// get the nearest next event, levels whose guard is false will be skipped
+func (fs *Fsm) getNextEvent(machineId uint, level uint, detail string) (rp Event) {
 	events := make([]Event, 0)
 	fs.session.
 		Preload("Name").
@@ -872,11 +1832,18 @@ func (fs *Fsm) getNextEvent(machineId uint, level uint) (rp Event) {
 		Preload("Roles").
 		Preload("Users").
 		Where("machine_id = ?", machineId).
-		Where("level = ?", level+1).
+		Where("level > ?", level).
+		Order("level").
 		Order("sort").
 		Find(&events)
 	for _, event := range events {
 		if strings.HasSuffix(event.Name.Name, i18n.T(constant.FsmSuffixWaiting)) || strings.HasSuffix(event.Name.Name, i18n.T(constant.FsmSuffixConfirm)) {
+			if !fs.matchGuard(event.Guard, detail) {
+				if fs.Error != nil {
+					return
+				}
+				continue
+			}
 			rp = event
 			return
 		}
@@ -884,6 +1851,47 @@ func (fs *Fsm) getNextEvent(machineId uint, level uint) (rp Event) {
 	return
 }
 
+// evaluate guard expression by submitter detail json(nested field use brackets, example: [order.amount] > 10000)
+func (fs *Fsm) matchGuard(guard, detail string) bool {
+	if strings.TrimSpace(guard) == "" {
+		return true
+	}
+	expr, err := govaluate.NewEvaluableExpression(guard)
+	if err != nil {
+		fs.AddError(errors.Wrap(i18n.E(ErrIllegalGuard), err.Error()))
+		return false
+	}
+	m := make(map[string]interface{})
+	if detail != "" {
+		utils.Json2Struct(detail, &m)
+	}
+	params := make(map[string]interface{})
+	flattenGuardParams("", m, params)
+	missing := false
+	for _, item := range expr.Vars() {
+		if _, ok := params[item]; !ok {
+			// field is not in detail, treat it as nil
+			params[item] = nil
+			missing = true
+		}
+	}
+	rs, err := expr.Evaluate(params)
+	if err != nil {
+		if missing {
+			// nil field cannot be compared, the guard is false
+			return false
+		}
+		fs.AddError(errors.Wrap(i18n.E(ErrIllegalGuard), err.Error()))
+		return false
+	}
+	match, ok := rs.(bool)
+	if !ok {
+		fs.AddError(errors.Wrap(i18n.E(ErrIllegalGuard), guard))
+		return false
+	}
+	return match
+}
+
 func (fs *Fsm) getEndEvent(machineId uint) (rp Event) {
 	fs.session.
 		Preload("Name").
@@ -952,7 +1960,8 @@ func (fs *Fsm) findEventDesc(machineId uint) (rp []fsm.EventDesc) {
 // L2 waiting refuse  / L1 approved               / L2 refused
 // L0 waiting confirm / L2 approved               / L0 confirmed
 // end
-func (fs *Fsm) batchCreateEvent(machineId uint, r []req.FsmCreateEvent) {
+// check levels before any event is changed
+func (fs *Fsm) checkLevels(r []req.FsmCreateEvent) {
 	if fs.Error != nil {
 		return
 	}
@@ -960,6 +1969,34 @@ func (fs *Fsm) batchCreateEvent(machineId uint, r []req.FsmCreateEvent) {
 		fs.AddError(i18n.E(ErrLevelsEmpty))
 		return
 	}
+	for _, item := range r {
+		// countersign quorum cannot exceed approver count
+		if uint(item.Countersign) == constant.One && uint(item.Quorum) > uint(len(item.Roles.Uints())+len(item.Users.Uints())) {
+			fs.AddError(errors.Wrap(i18n.E(ErrParams), "quorum"))
+			return
+		}
+		if uint(item.TimeoutAction) == constant.FsmTimeoutActionEscalate && item.FallbackRoleId == 0 {
+			fs.AddError(errors.Wrap(i18n.E(ErrParams), "fallbackRoleId"))
+			return
+		}
+		if strings.TrimSpace(item.Guard) != "" {
+			if _, err := govaluate.NewEvaluableExpression(item.Guard); err != nil {
+				fs.AddError(errors.Wrap(i18n.E(ErrIllegalGuard), item.Guard))
+				return
+			}
+		}
+	}
+	return
+}
+
+func (fs *Fsm) batchCreateEvent(machineId uint, r []req.FsmCreateEvent) {
+	if fs.Error != nil {
+		return
+	}
+	fs.checkLevels(r)
+	if fs.Error != nil {
+		return
+	}
 	// clear old machine
 	fs.session.
 		Unscoped().
@@ -1118,8 +2155,14 @@ func (fs *Fsm) batchCreateEvent(machineId uint, r []req.FsmCreateEvent) {
 		// default: no edit permission
 		edit := constant.Zero
 		editFields := ""
+		countersign := constant.Zero
+		quorum := constant.Zero
+		guard := ""
+		var remind, timeout, timeoutAction, fallbackRoleId uint
 		roles := make([]Role, 0)
 		users := make([]User, 0)
+		ccRoles := make([]Role, 0)
+		ccUsers := make([]User, 0)
 		if i == 0 {
 			// submitter has edit permission
 			edit = constant.One
@@ -1129,9 +2172,22 @@ func (fs *Fsm) batchCreateEvent(machineId uint, r []req.FsmCreateEvent) {
 			index := (i+1)/2 - 1
 			edit = uint(r[index].Edit)
 			editFields = r[index].EditFields
+			countersign = uint(r[index].Countersign)
+			quorum = uint(r[index].Quorum)
+			guard = strings.TrimSpace(r[index].Guard)
+			remind = uint(r[index].Remind)
+			timeout = uint(r[index].Timeout)
+			timeoutAction = uint(r[index].TimeoutAction)
+			fallbackRoleId = uint(r[index].FallbackRoleId)
+			if timeoutAction == constant.FsmTimeoutActionEscalate {
+				// save fallback role for escalating
+				fs.findRole([]uint{fallbackRoleId})
+			}
 			// find roles/users
 			roles = fs.findRole(r[index].Roles.Uints())
 			users = fs.findUser(r[index].Users.Uints())
+			ccRoles = fs.findRole(r[index].CcRoles.Uints())
+			ccUsers = fs.findUser(r[index].CcUsers.Uints())
 		} else if i == len(desc)-1 && machine.SubmitterConfirm == constant.One {
 			// save submitter confirm edit fields
 			edit = constant.One
@@ -1139,16 +2195,25 @@ func (fs *Fsm) batchCreateEvent(machineId uint, r []req.FsmCreateEvent) {
 		}
 
 		events = append(events, Event{
-			MachineId:  machineId,
-			Sort:       uint(i),
-			Level:      levels[d.Name],
-			NameId:     nameId,
-			Src:        src,
-			DstId:      dstId,
-			Edit:       edit,
-			EditFields: editFields,
-			Roles:      roles,
-			Users:      users,
+			MachineId:      machineId,
+			Sort:           uint(i),
+			Level:          levels[d.Name],
+			NameId:         nameId,
+			Src:            src,
+			DstId:          dstId,
+			Edit:           edit,
+			EditFields:     editFields,
+			Roles:          roles,
+			Users:          users,
+			CcRoles:        ccRoles,
+			CcUsers:        ccUsers,
+			Countersign:    countersign,
+			Quorum:         quorum,
+			Guard:          guard,
+			Remind:         remind,
+			Timeout:        timeout,
+			TimeoutAction:  timeoutAction,
+			FallbackRoleId: fallbackRoleId,
 		})
 	}
 	if len(events) > 0 {
@@ -1222,6 +2287,31 @@ func (fs *Fsm) AddError(err error) error {
 	return fs.Error
 }
 
+type taskPayload struct {
+	Category uint   `json:"category"`
+	Uuid     string `json:"uuid"`
+	LogId    uint   `json:"logId"`
+}
+
+func getTaskUid(name string, logId uint) string {
+	return fmt.Sprintf("%s.%d", name, logId)
+}
+
+// copy pending log with the same progress, used by add sign steps
+func newSignLog(l Log) (rp Log) {
+	rp.Category = l.Category
+	rp.Uuid = l.Uuid
+	rp.ProgressId = l.ProgressId
+	rp.SubmitterRoleId = l.SubmitterRoleId
+	rp.SubmitterUserId = l.SubmitterUserId
+	rp.Detail = l.Detail
+	rp.Remark = l.Remark
+	rp.SubmitterDetail = l.SubmitterDetail
+	rp.CurrentEventId = l.CurrentEventId
+	rp.NextEventId = l.NextEventId
+	return
+}
+
 func getNextItemName(approved uint, eventName string) string {
 	name := eventName
 	if strings.HasSuffix(eventName, i18n.T(constant.FsmSuffixWaiting)) {
@@ -1240,6 +2330,97 @@ func getNextItemName(approved uint, eventName string) string {
 	return name
 }
 
+// get event source item by suffix(used when levels are skipped)
+func getSrcItem(event Event, suffix string) (rp EventItem) {
+	for _, item := range event.Src {
+		if strings.HasSuffix(item.Name, i18n.T(suffix)) {
+			rp = item
+			return
+		}
+	}
+	return
+}
+
+// flatten nested detail json to guard params, example: {"order":{"amount":1}} => order.amount=1
+func flattenGuardParams(prefix string, m map[string]interface{}, params map[string]interface{}) {
+	for k, v := range m {
+		key := k
+		if prefix != "" {
+			key = fmt.Sprintf("%s.%s", prefix, k)
+		}
+		if child, ok := v.(map[string]interface{}); ok {
+			flattenGuardParams(key, child, params)
+			continue
+		}
+		params[key] = v
+	}
+}
+
+func matchDelegationCategory(delegation Delegation, category uint) bool {
+	if delegation.Categories == "" {
+		return true
+	}
+	return utils.ContainsUint(utils.Str2UintArr(delegation.Categories), category)
+}
+
+// get countersign voted approver count and required count(each user/role is an approver)
+func getCountersignProgress(l Log) (voted, quorum uint) {
+	roles := make([]uint, 0)
+	users := make([]uint, 0)
+	for _, role := range l.CanApprovalRoles {
+		roles = append(roles, role.Id)
+	}
+	for _, user := range l.CanApprovalUsers {
+		users = append(users, user.Id)
+	}
+	quorum = l.NextEvent.Quorum
+	if quorum == constant.Zero {
+		quorum = uint(len(roles) + len(users))
+	}
+	votedRoles := make([]uint, 0)
+	votedUsers := make([]uint, 0)
+	for _, vote := range l.Votes {
+		if vote.Approved != constant.FsmLogStatusApproved {
+			continue
+		}
+		userId, roleId := vote.approver()
+		if utils.ContainsUint(users, userId) {
+			if !utils.ContainsUint(votedUsers, userId) {
+				votedUsers = append(votedUsers, userId)
+			}
+		} else if utils.ContainsUint(roles, roleId) {
+			if !utils.ContainsUint(votedRoles, roleId) {
+				votedRoles = append(votedRoles, roleId)
+			}
+		}
+	}
+	voted = uint(len(votedRoles) + len(votedUsers))
+	return
+}
+
+func setCountersignTrack(l Log, track *resp.FsmLogTrack) {
+	if l.NextEvent.Countersign != constant.One {
+		return
+	}
+	track.Countersign = constant.One
+	track.Voted, track.Quorum = getCountersignProgress(l)
+	track.Votes = make([]resp.FsmLogVote, len(l.Votes))
+	for i, vote := range l.Votes {
+		track.Votes[i] = resp.FsmLogVote{
+			Time: resp.Time{
+				CreatedAt: vote.CreatedAt,
+				UpdatedAt: vote.UpdatedAt,
+			},
+			ApprovalRoleId:  vote.ApprovalRoleId,
+			ApprovalUserId:  vote.ApprovalUserId,
+			Approved:        vote.Approved,
+			Opinion:         vote.ApprovalOpinion,
+			DelegatorRoleId: vote.DelegatorRoleId,
+			DelegatorUserId: vote.DelegatorUserId,
+		}
+	}
+}
+
 // check that the state machine is valid(traverse each event, only one end position)
 func checkEvent(desc []fsm.EventDesc) (err error) {
 	names := make([]string, 0)
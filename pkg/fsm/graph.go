@@ -0,0 +1,148 @@
+package fsm
+
+import (
+	"fmt"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/i18n"
+	"github.com/piupuer/go-helper/pkg/req"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"strings"
+)
+
+// GetMachineGraph render machine as graphviz dot/mermaid text, current progress of log will be highlighted if uuid is not empty
+func (fs *Fsm) GetMachineGraph(r req.FsmMachineGraph) (rp resp.FsmMachineGraph) {
+	if fs.Error != nil {
+		return
+	}
+	var machine Machine
+	fs.session.
+		Model(&Machine{}).
+		Where("id = ?", r.Id).
+		First(&machine)
+	if machine.Id == constant.Zero {
+		fs.AddError(i18n.E(ErrMachineNotFound))
+		return
+	}
+	var l Log
+	if r.Uuid != "" {
+		fs.session.
+			Model(&Log{}).
+			Preload("Progress").
+			Preload("CurrentEvent").
+			Preload("NextEvent.Name").
+			Where("category = ?", machine.Category).
+			Where("uuid = ?", r.Uuid).
+			Order("id DESC").
+			First(&l)
+		rp.Progress = l.Progress.Name
+		if l.CurrentEvent.MachineId > constant.Zero && l.CurrentEvent.MachineId != machine.Id {
+			// log is running on history version
+			fs.session.
+				Model(&Machine{}).
+				Where("id = ?", l.CurrentEvent.MachineId).
+				First(&machine)
+		}
+	}
+	events := make([]Event, 0)
+	fs.session.
+		Preload("Name").
+		Preload("Src").
+		Preload("Dst").
+		Preload("Roles").
+		Preload("Users").
+		Where("machine_id = ?", machine.Id).
+		Order("sort").
+		Find(&events)
+
+	// state node ids keep the order of events
+	ids := make(map[string]string)
+	states := make([]string, 0)
+	addState := func(name string) {
+		if _, ok := ids[name]; !ok {
+			ids[name] = fmt.Sprintf("s%d", len(states))
+			states = append(states, name)
+		}
+	}
+	for _, event := range events {
+		for _, item := range event.Src {
+			addState(item.Name)
+		}
+		addState(event.Dst.Name)
+	}
+
+	var dot, mermaid strings.Builder
+	dot.WriteString("digraph fsm {\n")
+	dot.WriteString("  rankdir=LR;\n")
+	dot.WriteString("  node [shape=box, style=rounded];\n")
+	mermaid.WriteString("stateDiagram-v2\n")
+	for _, state := range states {
+		if rp.Progress != "" && state == rp.Progress {
+			dot.WriteString(fmt.Sprintf("  %q [color=red, style=\"rounded,bold\"];\n", state))
+		} else {
+			dot.WriteString(fmt.Sprintf("  %q;\n", state))
+		}
+		mermaid.WriteString(fmt.Sprintf("  state %q as %s\n", state, ids[state]))
+	}
+	for _, event := range events {
+		labels := getEventGraphLabels(machine, event)
+		// pending approval event of the log
+		pending := l.NextEventId > constant.Zero && event.Name.Name == l.NextEvent.Name.Name
+		for _, item := range event.Src {
+			attr := ""
+			if pending {
+				attr = ", color=red, penwidth=2"
+			}
+			dot.WriteString(fmt.Sprintf("  %q -> %q [label=%q%s];\n", item.Name, event.Dst.Name, strings.Join(labels, "\n"), attr))
+			mermaid.WriteString(fmt.Sprintf("  %s --> %s: %s\n", ids[item.Name], ids[event.Dst.Name], strings.Join(labels, ", ")))
+		}
+	}
+	if rp.Progress != "" {
+		if id, ok := ids[rp.Progress]; ok {
+			mermaid.WriteString("  classDef progress fill:#f96,stroke:#f00,stroke-width:2px\n")
+			mermaid.WriteString(fmt.Sprintf("  class %s progress\n", id))
+		}
+	}
+	dot.WriteString("}\n")
+	rp.Dot = dot.String()
+	rp.Mermaid = mermaid.String()
+	return
+}
+
+// event name, approvers and edit permission
+func getEventGraphLabels(machine Machine, event Event) (rp []string) {
+	rp = append(rp, event.Name.Name)
+	if len(event.Roles) == 0 && len(event.Users) == 0 {
+		// submitter resubmit/confirm
+		rp = append(rp, machine.SubmitterName)
+		fields := machine.SubmitterEditFields
+		if strings.HasSuffix(event.Name.Name, i18n.T(constant.FsmSuffixConfirm)) {
+			fields = machine.SubmitterConfirmEditFields
+		}
+		if fields != "" {
+			rp = append(rp, fmt.Sprintf("edit=%s", fields))
+		}
+		return
+	}
+	if len(event.Roles) > 0 {
+		roleIds := make([]string, 0)
+		for _, item := range event.Roles {
+			roleIds = append(roleIds, fmt.Sprintf("%d", item.Id))
+		}
+		rp = append(rp, fmt.Sprintf("roles=%s", strings.Join(roleIds, ",")))
+	}
+	if len(event.Users) > 0 {
+		userIds := make([]string, 0)
+		for _, item := range event.Users {
+			userIds = append(userIds, fmt.Sprintf("%d", item.Id))
+		}
+		rp = append(rp, fmt.Sprintf("users=%s", strings.Join(userIds, ",")))
+	}
+	if event.Edit == constant.One {
+		fields := event.EditFields
+		if fields == "" {
+			fields = "*"
+		}
+		rp = append(rp, fmt.Sprintf("edit=%s", fields))
+	}
+	return
+}
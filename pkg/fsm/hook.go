@@ -0,0 +1,90 @@
+package fsm
+
+import (
+	"context"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/mq"
+	"github.com/piupuer/go-helper/pkg/utils"
+	"gorm.io/gorm"
+)
+
+// Hook transition callback, tx is the same session as approval, return err will roll approval back
+type Hook func(ctx context.Context, tx *gorm.DB, e HookEvent) error
+
+// HookEvent fsm domain event
+type HookEvent struct {
+	Name    string  `json:"name"`
+	Machine Machine `json:"machine"`
+	// current processing log
+	Log Log `json:"log"`
+	// new log created by this transition(empty when submit/cancel)
+	Next  Log   `json:"next"`
+	Actor Actor `json:"actor"`
+}
+
+// Actor who triggers the transition
+type Actor struct {
+	RoleId uint `json:"roleId"`
+	UserId uint `json:"userId"`
+	// processed by system(timeout task/config changed)
+	System bool `json:"system"`
+}
+
+// WithOutbox save all transition events to mq outbox as json by the tx of approval, relay publishes them to exchange after commit,
+// rolled back approval publishes nothing. Route key is constant.FsmHookRouteKey + "." + event name
+func WithOutbox(ob *mq.Outbox, ex *mq.Exchange, publishOptions ...func(*mq.PublishOptions)) func(*Options) {
+	return func(options *Options) {
+		if ob == nil || ob.Error != nil || ex == nil || ex.Error != nil {
+			return
+		}
+		fun := func(ctx context.Context, tx *gorm.DB, e HookEvent) error {
+			ops := append([]func(*mq.PublishOptions){
+				mq.WithPublishContentType("application/json"),
+				mq.WithPublishRouteKey(constant.FsmHookRouteKey + "." + e.Name),
+			}, publishOptions...)
+			// outbox saves msg by tx of ctx
+			c := context.WithValue(ctx, constant.MiddlewareTransactionTxCtxKey, tx)
+			return ob.PublishJson(c, ex, utils.Struct2Json(e), ops...)
+		}
+		for _, name := range []string{
+			constant.FsmHookSubmit,
+			constant.FsmHookLevelApproved,
+			constant.FsmHookRejected,
+			constant.FsmHookResubmit,
+			constant.FsmHookEnd,
+			constant.FsmHookCancel,
+			constant.FsmHookWithdraw,
+		} {
+			withHook(name, fun)(options)
+		}
+	}
+}
+
+// fire all hooks of the event name
+func (fs *Fsm) fireHook(name string, l, next Log, actor Actor) {
+	if fs.Error != nil {
+		return
+	}
+	hooks := fs.ops.hooks[name]
+	if len(hooks) == 0 {
+		return
+	}
+	var machine Machine
+	fs.session.
+		Model(&Machine{}).
+		Where("id = (?)", fs.session.Model(&Event{}).Select("machine_id").Where("id = ?", l.CurrentEventId)).
+		First(&machine)
+	e := HookEvent{
+		Name:    name,
+		Machine: machine,
+		Log:     l,
+		Next:    next,
+		Actor:   actor,
+	}
+	for _, hook := range hooks {
+		if fs.AddError(hook(fs.hookCtx(), fs.session, e)) != nil {
+			return
+		}
+	}
+	return
+}
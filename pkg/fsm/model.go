@@ -1,6 +1,9 @@
 package fsm
 
-import "github.com/piupuer/go-helper/ms"
+import (
+	"github.com/golang-module/carbon/v2"
+	"github.com/piupuer/go-helper/ms"
+)
 
 type Machine struct {
 	ms.M
@@ -12,6 +15,9 @@ type Machine struct {
 	SubmitterConfirmEditFields string  `gorm:"comment:submitter can edit fields when confirm" json:"submitterConfirmEditFields"`
 	EventsJson                 string  `gorm:"comment:event json str" json:"eventsJson"`
 	Events                     []Event `gorm:"foreignKey:MachineId" json:"events"`
+	// each update creates a new version, history version keeps events of logs in progress
+	Version uint `gorm:"default:1;comment:version(increase when machine updated)" json:"version"`
+	HeadId  uint `gorm:"index:idx_head_id;default:0;comment:latest machine id(0: latest version, >0: history version)" json:"headId"`
 }
 
 type Event struct {
@@ -29,6 +35,19 @@ type Event struct {
 	EditFields string      `gorm:"comment:approver can edit fields(split by comma, can edit all field if it empty, edit=1 take effect)" json:"editFields"`
 	Roles      []Role      `gorm:"many2many:event_role_relation;comment:approver role ids" json:"roles"`
 	Users      []User      `gorm:"many2many:event_user_relation;comment:approver user ids" json:"users"`
+	// countersign level will not pass until all approvers(or quorum) approved
+	Countersign uint `gorm:"type:tinyint(1);default:0;comment:countersign(0: any approver, 1: all approvers or quorum)" json:"countersign"`
+	Quorum      uint `gorm:"default:0;comment:countersign required approvals(0: all approvers, countersign=1 take effect)" json:"quorum"`
+	// level will be skipped if guard expression is false(evaluate by log submitter detail)
+	Guard string `gorm:"comment:guard expression(level always take effect if it empty)" json:"guard"`
+	// approval SLA, remind/timeout task will be scheduled by delay queue
+	Remind         uint `gorm:"default:0;comment:remind approvers after hours(0: no remind)" json:"remind"`
+	Timeout        uint `gorm:"default:0;comment:timeout hours(0: never timeout)" json:"timeout"`
+	TimeoutAction  uint `gorm:"type:tinyint(1);default:0;comment:timeout action(0: none, 1: escalate, 2: auto approve, 3: auto refuse)" json:"timeoutAction"`
+	FallbackRoleId uint `gorm:"default:0;comment:escalate to fallback role id(timeoutAction=1 take effect)" json:"fallbackRoleId"`
+	// carbon copy recipients will be notified when log reaches/passes/is refused at the level
+	CcRoles []Role `gorm:"many2many:event_cc_role_relation;comment:carbon copy role ids" json:"ccRoles"`
+	CcUsers []User `gorm:"many2many:event_cc_user_relation;comment:carbon copy user ids" json:"ccUsers"`
 }
 
 type User struct {
@@ -74,6 +93,7 @@ type Log struct {
 	PrevDetail       string    `gorm:"comment:last approver detail" json:"prevDetail"`
 	Detail           string    `gorm:"comment:current approver detail" json:"detail"`
 	Remark           string    `gorm:"size:100;comment:remark(approving will use it)" json:"remark"`
+	SubmitterDetail  string    `gorm:"type:text;comment:submitter detail json(level guard will use it)" json:"submitterDetail"`
 	CurrentEventId   uint      `gorm:"comment:current event id" json:"currentEventId"`
 	CurrentEvent     Event     `gorm:"foreignKey:CurrentEventId;comment:current event" json:"currentEvent"`
 	Resubmit         uint      `gorm:"type:tinyint(1);default:0;comment:waiting submitter resubmit" json:"resubmit"`
@@ -82,6 +102,54 @@ type Log struct {
 	NextEvent        Event     `gorm:"foreignKey:NextEventId;comment:next event" json:"nextEvent"`
 	CanApprovalRoles []Role    `gorm:"many2many:log_approval_role_relation;comment:can approve roles" json:"canApprovalRoles"`
 	CanApprovalUsers []User    `gorm:"many2many:log_approval_user_relation;comment:can approve users" json:"canApprovalUsers"`
+	Votes            []LogVote `gorm:"foreignKey:LogId" json:"votes"`
+	SystemApproved   uint      `gorm:"type:tinyint(1);default:0;comment:approved by system when timeout" json:"systemApproved"`
+	Escalated        uint      `gorm:"type:tinyint(1);default:0;comment:escalated to fallback role when timeout" json:"escalated"`
+	DelegatorRoleId  uint      `gorm:"comment:approved on behalf of delegator role id" json:"delegatorRoleId"`
+	DelegatorUserId  uint      `gorm:"comment:approved on behalf of delegator user id" json:"delegatorUserId"`
+	RejectTo         string    `gorm:"comment:approver refused back to the level" json:"rejectTo"`
+	// ad-hoc approval step added by approver, the machine will not be changed
+	AddSign   uint `gorm:"type:tinyint(1);default:0;comment:add sign step(0: none, 1: before approver, 2: after approver)" json:"addSign"`
+	SignLogId uint `gorm:"default:0;comment:the log which approver added sign on" json:"signLogId"`
+	// submitter detail diff json of the step which created the log
+	DetailDiff string `gorm:"type:text;comment:submitter detail diff json" json:"detailDiff"`
+	// submitter detail diff json edited by approver of the log before approve
+	EditDiff string `gorm:"type:text;comment:submitter detail diff json edited by approver" json:"editDiff"`
+}
+
+// LogVote countersign approver record, the pending log keeps its progress until quorum reached
+type LogVote struct {
+	ms.M
+	LogId           uint   `gorm:"index:idx_log_id;comment:pending log id" json:"logId"`
+	EventId         uint   `gorm:"comment:countersign event id" json:"eventId"`
+	ApprovalRoleId  uint   `gorm:"comment:approver role id" json:"approvalRoleId"`
+	ApprovalUserId  uint   `gorm:"comment:approver user id" json:"approvalUserId"`
+	Approved        uint   `gorm:"type:tinyint(1);default:0;comment:approval status" json:"approved"`
+	ApprovalOpinion string `gorm:"comment:approver approval opinion" json:"approvalOpinion"`
+	DelegatorRoleId uint   `gorm:"comment:approved on behalf of delegator role id" json:"delegatorRoleId"`
+	DelegatorUserId uint   `gorm:"comment:approved on behalf of delegator user id" json:"delegatorUserId"`
+}
+
+// approver of the vote(delegator if approved on behalf of it)
+func (v LogVote) approver() (userId, roleId uint) {
+	userId = v.ApprovalUserId
+	roleId = v.ApprovalRoleId
+	if v.DelegatorUserId > 0 || v.DelegatorRoleId > 0 {
+		userId = v.DelegatorUserId
+		roleId = v.DelegatorRoleId
+	}
+	return
+}
+
+// Delegation approver delegates approval permission to proxy user in a time window
+type Delegation struct {
+	ms.M
+	FromUserId uint            `gorm:"index:idx_from_user_id;comment:delegator user id" json:"fromUserId"`
+	FromRoleId uint            `gorm:"comment:delegator role id(0: only delegate user approval)" json:"fromRoleId"`
+	ToUserId   uint            `gorm:"index:idx_to_user_id;comment:proxy user id" json:"toUserId"`
+	StartAt    carbon.DateTime `gorm:"comment:start time" json:"startAt"`
+	EndAt      carbon.DateTime `gorm:"comment:end time" json:"endAt"`
+	Categories string          `gorm:"comment:machine categories(split by comma, all categories if it empty)" json:"categories"`
 }
 
 type LogApprovalRoleRelation struct {
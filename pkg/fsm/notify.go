@@ -0,0 +1,74 @@
+package fsm
+
+import (
+	"github.com/piupuer/go-helper/pkg/constant"
+)
+
+// Notification approval notification of a level
+type Notification struct {
+	// constant.FsmNotifyReach/FsmNotifyPass/FsmNotifyReject
+	Action     string `json:"action"`
+	Category   uint   `json:"category"`
+	Uuid       string `json:"uuid"`
+	Machine    string `json:"machine"`
+	Level      string `json:"level"`
+	Opinion    string `json:"opinion"`
+	FromUserId uint   `json:"fromUserId"`
+	// approvers of pending log(action=reach take effect)
+	ApproverUserIds []uint `json:"approverUserIds"`
+	ApproverRoleIds []uint `json:"approverRoleIds"`
+	// carbon copy recipients of the level
+	CcUserIds []uint `json:"ccUserIds"`
+	CcRoleIds []uint `json:"ccRoleIds"`
+}
+
+// notify approvers of log and carbon copy recipients of the event
+func (fs *Fsm) notify(action string, eventId uint, l Log, fromUserId uint, opinion string) {
+	if fs.Error != nil || fs.ops.notify == nil || eventId == constant.Zero {
+		return
+	}
+	var event Event
+	fs.session.
+		Preload("Name").
+		Preload("Machine").
+		Preload("CcRoles").
+		Preload("CcUsers").
+		Where("id = ?", eventId).
+		First(&event)
+	n := Notification{
+		Action:          action,
+		Category:        l.Category,
+		Uuid:            l.Uuid,
+		Machine:         event.Machine.Name,
+		Level:           event.Name.Name,
+		Opinion:         opinion,
+		FromUserId:      fromUserId,
+		ApproverUserIds: make([]uint, 0),
+		ApproverRoleIds: make([]uint, 0),
+		CcUserIds:       make([]uint, 0),
+		CcRoleIds:       make([]uint, 0),
+	}
+	if action == constant.FsmNotifyReach {
+		for _, item := range l.CanApprovalUsers {
+			if item.Id > constant.Zero {
+				n.ApproverUserIds = append(n.ApproverUserIds, item.Id)
+			}
+		}
+		for _, item := range l.CanApprovalRoles {
+			if item.Id > constant.Zero {
+				n.ApproverRoleIds = append(n.ApproverRoleIds, item.Id)
+			}
+		}
+	}
+	for _, item := range event.CcUsers {
+		n.CcUserIds = append(n.CcUserIds, item.Id)
+	}
+	for _, item := range event.CcRoles {
+		n.CcRoleIds = append(n.CcRoleIds, item.Id)
+	}
+	if len(n.ApproverUserIds)+len(n.ApproverRoleIds)+len(n.CcUserIds)+len(n.CcRoleIds) == 0 {
+		return
+	}
+	fs.AddError(fs.ops.notify(fs.hookCtx(), n))
+	return
+}
@@ -3,6 +3,7 @@ package fsm
 import (
 	"context"
 	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/delay"
 	"github.com/piupuer/go-helper/pkg/resp"
 	"github.com/piupuer/go-helper/pkg/utils"
 	"gorm.io/gorm"
@@ -13,6 +14,10 @@ type Options struct {
 	db         *gorm.DB
 	prefix     string
 	transition func(ctx context.Context, logs ...resp.FsmApprovalLog) error
+	queue      *delay.Queue
+	remind     func(ctx context.Context, l Log) error
+	hooks      map[string][]Hook
+	notify     func(ctx context.Context, n Notification) error
 }
 
 func WithCtx(ctx context.Context) func(*Options) {
@@ -43,6 +48,79 @@ func WithTransition(fun func(ctx context.Context, logs ...resp.FsmApprovalLog) e
 	}
 }
 
+// WithQueue approval remind/timeout tasks will be scheduled by delay queue
+func WithQueue(qu *delay.Queue) func(*Options) {
+	return func(options *Options) {
+		if qu != nil && qu.Error == nil {
+			getOptionsOrSetDefault(options).queue = qu
+		}
+	}
+}
+
+func WithRemind(fun func(ctx context.Context, l Log) error) func(*Options) {
+	return func(options *Options) {
+		if fun != nil {
+			getOptionsOrSetDefault(options).remind = fun
+		}
+	}
+}
+
+// WithNotify notify approvers and carbon copy recipients when log reaches/passes/is refused at a level
+func WithNotify(fun func(ctx context.Context, n Notification) error) func(*Options) {
+	return func(options *Options) {
+		if fun != nil {
+			getOptionsOrSetDefault(options).notify = fun
+		}
+	}
+}
+
+// WithOnSubmit hook after submitter create log
+func WithOnSubmit(fun Hook) func(*Options) {
+	return withHook(constant.FsmHookSubmit, fun)
+}
+
+// WithOnLevelApproved hook after a level approved
+func WithOnLevelApproved(fun Hook) func(*Options) {
+	return withHook(constant.FsmHookLevelApproved, fun)
+}
+
+// WithOnRejected hook after a level refused
+func WithOnRejected(fun Hook) func(*Options) {
+	return withHook(constant.FsmHookRejected, fun)
+}
+
+// WithOnResubmit hook after submitter resubmit refused log
+func WithOnResubmit(fun Hook) func(*Options) {
+	return withHook(constant.FsmHookResubmit, fun)
+}
+
+// WithOnEnd hook after the whole approval ended
+func WithOnEnd(fun Hook) func(*Options) {
+	return withHook(constant.FsmHookEnd, fun)
+}
+
+// WithOnCancel hook after log cancelled by submitter/manual/config changed
+func WithOnCancel(fun Hook) func(*Options) {
+	return withHook(constant.FsmHookCancel, fun)
+}
+
+// WithOnWithdraw hook after submitter withdrawn log
+func WithOnWithdraw(fun Hook) func(*Options) {
+	return withHook(constant.FsmHookWithdraw, fun)
+}
+
+func withHook(name string, fun Hook) func(*Options) {
+	return func(options *Options) {
+		if fun != nil {
+			ops := getOptionsOrSetDefault(options)
+			if ops.hooks == nil {
+				ops.hooks = make(map[string][]Hook)
+			}
+			ops.hooks[name] = append(ops.hooks[name], fun)
+		}
+	}
+}
+
 func getOptionsOrSetDefault(options *Options) *Options {
 	if options == nil {
 		return &Options{
@@ -60,6 +60,16 @@ func T(id string) string {
 	})
 }
 
+// TWithData translate with template data, example: '{{.Name}} approved'
+func TWithData(id string, data interface{}) string {
+	return localizer.MustLocalize(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID: id,
+		},
+		TemplateData: data,
+	})
+}
+
 func E(id string) error {
 	return fmt.Errorf(T(id))
 }
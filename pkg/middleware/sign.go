@@ -5,6 +5,7 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-module/carbon/v2"
 	"github.com/piupuer/go-helper/pkg/constant"
@@ -139,6 +140,12 @@ func Sign(options ...func(*SignOptions)) gin.HandlerFunc {
 }
 
 func verifySign(secret, signature, method, uri, timestamp, body string) (flag bool) {
+	flag = GenSign(secret, method, uri, timestamp, body) == signature
+	return
+}
+
+// GenSign hmac sha256 signature of request, uri contains path and query, example: /api/v1/base/user?id=1
+func GenSign(secret, method, uri, timestamp, body string) string {
 	b := bytes.NewBuffer(nil)
 	b.WriteString(method)
 	b.WriteString(constant.MiddlewareSignSeparator)
@@ -149,9 +156,21 @@ func verifySign(secret, signature, method, uri, timestamp, body string) (flag bo
 	b.WriteString(utils.JsonWithSort(body))
 	hash := hmac.New(sha256.New, []byte(secret))
 	hash.Write(b.Bytes())
-	digest := base64.StdEncoding.EncodeToString(hash.Sum(nil))
-	flag = digest == signature
-	return
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil))
+}
+
+// GenSignToken header value of constant.MiddlewareSignTokenHeaderKey, example: appid="id",timestamp="1640000000",signature="xxx"
+func GenSignToken(appId, secret, method, uri, body string) string {
+	timestamp := fmt.Sprintf("%d", carbon.Now().Timestamp())
+	return fmt.Sprintf(
+		`%s="%s",%s="%s",%s="%s"`,
+		constant.MiddlewareSignAppIdHeaderKey,
+		appId,
+		constant.MiddlewareSignTimestampHeaderKey,
+		timestamp,
+		constant.MiddlewareSignSignatureHeaderKey,
+		GenSign(secret, method, uri, timestamp, body),
+	)
 }
 
 func abort(c *gin.Context, format interface{}, a ...interface{}) {
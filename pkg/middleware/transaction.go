@@ -1,8 +1,10 @@
 package middleware
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
 	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/log"
 	"github.com/piupuer/go-helper/pkg/resp"
 	"github.com/piupuer/go-helper/pkg/tracing"
 	"github.com/thoas/go-funk"
@@ -28,6 +30,8 @@ func Transaction(options ...func(*TransactionOptions)) gin.HandlerFunc {
 		if funk.ContainsString(ops.forceTransactionPath, c.Request.URL.Path) {
 			noTransaction = false
 		}
+		// funcs registered by query.MySql.AfterCommit
+		afterCommit := make([]func(), 0)
 		defer func() {
 			ctx := tracing.RealCtx(c)
 			_, span := tracer.Start(ctx, tracing.Name(tracing.Middleware, "Transaction"))
@@ -39,7 +43,9 @@ func Transaction(options ...func(*TransactionOptions)) gin.HandlerFunc {
 					if !noTransaction {
 						if rp.Code == resp.Ok || c.GetBool(constant.MiddlewareTransactionForceCommitCtxKey) {
 							// commit transaction
-							tx.Commit()
+							if tx.Commit().Error == nil {
+								runAfterCommit(ctx, afterCommit)
+							}
 						} else {
 							// rollback transaction
 							tx.Rollback()
@@ -56,8 +62,8 @@ func Transaction(options ...func(*TransactionOptions)) gin.HandlerFunc {
 				// throw up exception
 				panic(err)
 			} else {
-				if !noTransaction {
-					tx.Commit()
+				if !noTransaction && tx.Commit().Error == nil {
+					runAfterCommit(ctx, afterCommit)
 				}
 			}
 			c.Abort()
@@ -65,6 +71,7 @@ func Transaction(options ...func(*TransactionOptions)) gin.HandlerFunc {
 		if !noTransaction {
 			tx := ops.dbNoTx.Begin()
 			c.Set(constant.MiddlewareTransactionTxCtxKey, tx)
+			c.Set(constant.MiddlewareTransactionAfterCommitCtxKey, &afterCommit)
 		}
 		c.Next()
 	}
@@ -80,3 +87,16 @@ func getTx(c *gin.Context, ops TransactionOptions) *gorm.DB {
 	}
 	return tx
 }
+
+func runAfterCommit(ctx context.Context, list []func()) {
+	for _, fun := range list {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					log.WithContext(ctx).Error("run after commit failed: %v", err)
+				}
+			}()
+			fun()
+		}()
+	}
+}
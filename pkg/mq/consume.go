@@ -32,7 +32,12 @@ func (qu *Queue) Consume(handler func(context.Context, string, amqp.Delivery) bo
 		d := msg.Delivery
 		a := d.Acknowledger
 		tag := d.DeliveryTag
+		key, skip := co.dedup(ctx, qu, d)
+		if skip {
+			return
+		}
 		ok := handler(ctx, co.q, d)
+		co.dedupDone(ctx, key, ok)
 		if co.ops.autoAck {
 			return
 		}
@@ -43,6 +48,11 @@ func (qu *Queue) Consume(handler func(context.Context, string, amqp.Delivery) bo
 			}
 			return
 		}
+		if qu.ops.retry > 0 {
+			// delay retry instead of requeue
+			qu.retry(ctx, d, co.ops.nackRequeue)
+			return
+		}
 		e := a.Nack(tag, false, co.ops.nackRequeue)
 		if e != nil {
 			log.WithContext(ctx).WithError(e).Error("consume nack failed")
@@ -86,7 +96,12 @@ func (qu *Queue) ConsumeOne(size int, handler func(c context.Context, q string,
 		a := d.Acknowledger
 		tag := d.DeliveryTag
 		ctx = context.WithValue(ctx, "index", i)
+		key, skip := co.dedup(ctx, qu, d)
+		if skip {
+			continue
+		}
 		ok := handler(ctx, co.q, d)
+		co.dedupDone(ctx, key, ok)
 		if co.ops.autoAck {
 			return
 		}
@@ -97,6 +112,11 @@ func (qu *Queue) ConsumeOne(size int, handler func(c context.Context, q string,
 			}
 			return
 		}
+		if qu.ops.retry > 0 {
+			// delay retry instead of requeue
+			qu.retry(ctx, d, co.ops.nackRequeue)
+			continue
+		}
 		// get retry count
 		var retryCount int32
 		if v, o := d.Headers["x-retry-count"].(int32); o {
@@ -3,13 +3,14 @@ package mq
 import (
 	"context"
 	"fmt"
+	"github.com/go-redis/redis/v8"
 	"github.com/streadway/amqp"
-	"os"
 	"testing"
 	"time"
 )
 
 func TestQueue_Consume(t *testing.T) {
+	skipIfUnreachable(t, rabbitAddr)
 	ex := NewRabbit(uri).
 		Exchange(
 			WithExchangeName("ex1"),
@@ -33,7 +34,7 @@ func TestQueue_Consume(t *testing.T) {
 	if err != nil {
 		fmt.Println(err)
 	}
-	os.Exit(0)
+	time.Sleep(3 * time.Second)
 }
 
 func handler(ctx context.Context, q string, delivery amqp.Delivery) bool {
@@ -42,12 +43,13 @@ func handler(ctx context.Context, q string, delivery amqp.Delivery) bool {
 }
 
 func TestQueue_ConsumeOne(t *testing.T) {
+	skipIfUnreachable(t, rabbitAddr)
 	rb := NewRabbit(uri)
 	if rb.Error != nil {
 		panic(rb.Error)
 	}
-	for {
-		time.Sleep(10 * time.Second)
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Second)
 		err := rb.
 			Exchange(
 				WithExchangeName("ex1"),
@@ -64,3 +66,83 @@ func TestQueue_ConsumeOne(t *testing.T) {
 		}
 	}
 }
+
+func TestQueue_ConsumeRetry(t *testing.T) {
+	skipIfUnreachable(t, rabbitAddr)
+	ex := NewRabbit(uri).
+		Exchange(
+			WithExchangeName("ex1"),
+		)
+	if ex.Error != nil {
+		panic(ex.Error)
+	}
+	// declare q4.retry.1(1s) q4.retry.2(2s) q4.retry.3(4s) and q4.parking
+	qu := ex.Queue(
+		WithQueueName("q4"),
+		WithQueueRouteKeys("rt4"),
+		WithQueueRetry(3),
+		WithQueueRetryBackoff(1000),
+	)
+	if qu.Error != nil {
+		panic(qu.Error)
+	}
+	err := ex.PublishJson(`{"id":1}`, WithPublishRouteKey("rt4"))
+	if err != nil {
+		panic(err)
+	}
+
+	err = qu.Consume(
+		func(ctx context.Context, q string, d amqp.Delivery) bool {
+			fmt.Println(time.Now(), q, d.Headers)
+			return false
+		},
+	)
+	if err != nil {
+		fmt.Println(err)
+	}
+	time.Sleep(10 * time.Second)
+}
+
+func TestQueue_ConsumeDedup(t *testing.T) {
+	skipIfUnreachable(t, rabbitAddr, redisAddr)
+	ex := NewRabbit(uri).
+		Exchange(
+			WithExchangeName("ex1"),
+		)
+	if ex.Error != nil {
+		panic(ex.Error)
+	}
+	qu := ex.Queue(
+		WithQueueName("q5"),
+		WithQueueRouteKeys("rt5"),
+	)
+	if qu.Error != nil {
+		panic(qu.Error)
+	}
+	// publish the same msg twice, handler only see it once
+	for i := 0; i < 2; i++ {
+		err := ex.PublishJson(
+			`{"id":1}`,
+			WithPublishRouteKey("rt5"),
+			WithPublishHeaders(amqp.Table{
+				"x-msg-id": "msg1",
+			}),
+		)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	err := qu.Consume(
+		handler,
+		WithConsumeDedup(redis.NewClient(&redis.Options{
+			Addr: redisAddr,
+		})),
+		WithConsumeDedupHeader("x-msg-id"),
+		WithConsumeDedupExpire(60),
+	)
+	if err != nil {
+		fmt.Println(err)
+	}
+	time.Sleep(3 * time.Second)
+}
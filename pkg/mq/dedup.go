@@ -0,0 +1,78 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/log"
+	"github.com/streadway/amqp"
+	"time"
+)
+
+// dedup mark msg in progress before handle it, skip means it is duplicated(acked) or in progress by other consumer(delayed)
+func (co *Consume) dedup(ctx context.Context, qu *Queue, d amqp.Delivery) (key string, skip bool) {
+	rd := co.ops.dedupRedis
+	if rd == nil {
+		return
+	}
+	id := d.MessageId
+	if co.ops.dedupHeader != "" {
+		id = ""
+		if v, ok := d.Headers[co.ops.dedupHeader]; ok {
+			id = fmt.Sprintf("%v", v)
+		}
+	}
+	if id == "" {
+		// no msg id, process it anyway
+		return
+	}
+	key = fmt.Sprintf("%s.%s.%s", co.ops.dedupKeyPrefix, co.q, id)
+	ok, err := rd.SetNX(ctx, key, constant.MqDedupProcessing, time.Duration(co.ops.dedupProcessingExpire)*time.Second).Result()
+	if err != nil {
+		log.WithContext(ctx).WithError(err).Warn("consume dedup mark %s failed, process it anyway", key)
+		key = ""
+		return
+	}
+	if ok {
+		return
+	}
+	skip = true
+	v, err := rd.Get(ctx, key).Result()
+	if err == redis.Nil {
+		// marker expired just now, let it be redelivered
+		v = constant.MqDedupProcessing
+	}
+	if co.ops.autoAck {
+		return
+	}
+	a := d.Acknowledger
+	if v == constant.MqDedupDone {
+		log.WithContext(ctx).Info("consume dedup skip processed msg %s", key)
+		if e := a.Ack(d.DeliveryTag, false); e != nil {
+			log.WithContext(ctx).WithError(e).Error("consume dedup ack failed")
+		}
+		return
+	}
+	// other consumer is processing it, redeliver it later in case that consumer fails(the attempt is not counted)
+	log.WithContext(ctx).Info("consume dedup delay in progress msg %s", key)
+	qu.delay(ctx, d, true)
+	return
+}
+
+// dedupDone save processed id, or remove in-progress marker so that msg can be retried
+func (co *Consume) dedupDone(ctx context.Context, key string, ok bool) {
+	if key == "" {
+		return
+	}
+	rd := co.ops.dedupRedis
+	var err error
+	if ok {
+		err = rd.Set(ctx, key, constant.MqDedupDone, time.Duration(co.ops.dedupExpire)*time.Second).Err()
+	} else {
+		err = rd.Del(ctx, key).Err()
+	}
+	if err != nil {
+		log.WithContext(ctx).WithError(err).Warn("consume dedup save %s failed", key)
+	}
+}
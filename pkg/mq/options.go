@@ -2,9 +2,12 @@ package mq
 
 import (
 	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/piupuer/go-helper/pkg/constant"
 	"github.com/piupuer/go-helper/pkg/utils"
 	"github.com/streadway/amqp"
 	"github.com/thoas/go-funk"
+	"gorm.io/gorm"
 )
 
 type RabbitOptions struct {
@@ -177,6 +180,10 @@ type QueueOptions struct {
 	deadLetterKey  string
 	messageTTL     int32
 	namePrefix     string
+	// max retry attempts of failed msg, retry queues will be declared if it > 0
+	retry           int32
+	retryBackoff    int // first retry delay milliseconds, doubled each attempt
+	retryMaxBackoff int
 }
 
 func WithQueueName(name string) func(*QueueOptions) {
@@ -257,12 +264,39 @@ func WithQueueMessageTTL(ttl int32) func(*QueueOptions) {
 	}
 }
 
+// WithQueueRetry failed msg will be delayed by per-attempt ttl queue and routed back, parked after max attempts
+func WithQueueRetry(count int32) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if count >= 0 {
+			getQueueOptionsOrSetDefault(options).retry = count
+		}
+	}
+}
+
+func WithQueueRetryBackoff(milli int) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if milli > 0 {
+			getQueueOptionsOrSetDefault(options).retryBackoff = milli
+		}
+	}
+}
+
+func WithQueueRetryMaxBackoff(milli int) func(*QueueOptions) {
+	return func(options *QueueOptions) {
+		if milli > 0 {
+			getQueueOptionsOrSetDefault(options).retryMaxBackoff = milli
+		}
+	}
+}
+
 func getQueueOptionsOrSetDefault(options *QueueOptions) *QueueOptions {
 	if options == nil {
 		return &QueueOptions{
-			durable: true,
-			declare: true,
-			bind:    true,
+			durable:         true,
+			declare:         true,
+			bind:            true,
+			retryBackoff:    1000,
+			retryMaxBackoff: constant.MqRetryMaxBackoff,
 		}
 	}
 	return options
@@ -283,6 +317,12 @@ type PublishOptions struct {
 	expiration           string
 	deadLetter           bool
 	deadLetterFirstQueue string
+	// wait for broker ack/nack until timeout, publish with mandatory flag
+	confirm bool
+	// unroutable msg will be passed to handler instead of error
+	returnHandler func(ctx context.Context, r amqp.Return)
+	// msg properties kept by republish/relay
+	properties *amqp.Publishing
 }
 
 func WithPublishCtx(ctx context.Context) func(*PublishOptions) {
@@ -362,6 +402,31 @@ func WithPublishDeadLetterFirstQueue(q string) func(*PublishOptions) {
 	}
 }
 
+// WithPublishConfirm block until broker acks/nacks all msg or timeout(WithPublishTimeout),
+// unroutable msg returns ErrPublishReturned if no return handler
+func WithPublishConfirm(flag bool) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		getPublishOptionsOrSetDefault(options).confirm = flag
+	}
+}
+
+// WithPublishReturnHandler handle unroutable msg in confirm mode
+func WithPublishReturnHandler(fun func(ctx context.Context, r amqp.Return)) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		if fun != nil {
+			getPublishOptionsOrSetDefault(options).returnHandler = fun
+		}
+	}
+}
+
+// WithPublishProperties keep MessageId/CorrelationId/ReplyTo/Priority/Type/AppId/UserId/Timestamp of msg,
+// it does not turn on confirm mode, use WithPublishConfirm(true) if needed
+func WithPublishProperties(p amqp.Publishing) func(*PublishOptions) {
+	return func(options *PublishOptions) {
+		getPublishOptionsOrSetDefault(options).properties = &p
+	}
+}
+
 func getPublishOptionsOrSetDefault(options *PublishOptions) *PublishOptions {
 	if options == nil {
 		return &PublishOptions{
@@ -389,6 +454,13 @@ type ConsumeOptions struct {
 	nackMaxRetryCount int32
 	autoRequestId     bool
 	oneCtx            context.Context
+	// skip processed msg by id saved in redis
+	dedupRedis     redis.UniversalClient
+	dedupHeader    string // msg id header, MessageId will be used if it empty
+	dedupKeyPrefix string
+	dedupExpire    int // seconds of processed id
+	// seconds of in-progress marker, other consumers can not process the same msg before it expired
+	dedupProcessingExpire int
 }
 
 func WithConsumeQosPrefetchCount(prefetchCount int) func(*ConsumeOptions) {
@@ -459,11 +531,152 @@ func WithConsumeOneContext(ctx context.Context) func(*ConsumeOptions) {
 	}
 }
 
+// WithConsumeDedup duplicate msg will be acked without calling handler
+func WithConsumeDedup(rd redis.UniversalClient) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		if rd != nil {
+			getConsumeOptionsOrSetDefault(options).dedupRedis = rd
+		}
+	}
+}
+
+func WithConsumeDedupHeader(key string) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		getConsumeOptionsOrSetDefault(options).dedupHeader = key
+	}
+}
+
+func WithConsumeDedupKeyPrefix(prefix string) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		if prefix != "" {
+			getConsumeOptionsOrSetDefault(options).dedupKeyPrefix = prefix
+		}
+	}
+}
+
+func WithConsumeDedupExpire(second int) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		if second > 0 {
+			getConsumeOptionsOrSetDefault(options).dedupExpire = second
+		}
+	}
+}
+
+func WithConsumeDedupProcessingExpire(second int) func(*ConsumeOptions) {
+	return func(options *ConsumeOptions) {
+		if second > 0 {
+			getConsumeOptionsOrSetDefault(options).dedupProcessingExpire = second
+		}
+	}
+}
+
 func getConsumeOptionsOrSetDefault(options *ConsumeOptions) *ConsumeOptions {
 	if options == nil {
 		return &ConsumeOptions{
-			qosPrefetchCount:  2,
-			nackMaxRetryCount: 5,
+			qosPrefetchCount:      2,
+			nackMaxRetryCount:     5,
+			dedupKeyPrefix:        constant.MqDedupKeyPrefix,
+			dedupExpire:           86400,
+			dedupProcessingExpire: 60,
+		}
+	}
+	return options
+}
+
+type OutboxOptions struct {
+	ctx      context.Context
+	dbNoTx   *gorm.DB
+	tbPrefix string
+	// relay of multiple instances are coordinated by redis lock
+	redis    redis.UniversalClient
+	lockKey  string
+	interval int // relay interval milliseconds
+	batch    int
+	maxRetry int
+	// first retry delay seconds, doubled each retry
+	retryBackoff int
+}
+
+func WithOutboxCtx(ctx context.Context) func(*OutboxOptions) {
+	return func(options *OutboxOptions) {
+		if !utils.InterfaceIsNil(ctx) {
+			getOutboxOptionsOrSetDefault(options).ctx = ctx
+		}
+	}
+}
+
+func WithOutboxDbNoTx(db *gorm.DB) func(*OutboxOptions) {
+	return func(options *OutboxOptions) {
+		if db != nil {
+			getOutboxOptionsOrSetDefault(options).dbNoTx = db
+		}
+	}
+}
+
+func WithOutboxTbPrefix(prefix string) func(*OutboxOptions) {
+	return func(options *OutboxOptions) {
+		getOutboxOptionsOrSetDefault(options).tbPrefix = prefix
+	}
+}
+
+func WithOutboxRedis(rd redis.UniversalClient) func(*OutboxOptions) {
+	return func(options *OutboxOptions) {
+		if rd != nil {
+			getOutboxOptionsOrSetDefault(options).redis = rd
+		}
+	}
+}
+
+func WithOutboxLockKey(key string) func(*OutboxOptions) {
+	return func(options *OutboxOptions) {
+		if key != "" {
+			getOutboxOptionsOrSetDefault(options).lockKey = key
+		}
+	}
+}
+
+func WithOutboxInterval(milli int) func(*OutboxOptions) {
+	return func(options *OutboxOptions) {
+		if milli > 0 {
+			getOutboxOptionsOrSetDefault(options).interval = milli
+		}
+	}
+}
+
+func WithOutboxBatch(count int) func(*OutboxOptions) {
+	return func(options *OutboxOptions) {
+		if count > 0 {
+			getOutboxOptionsOrSetDefault(options).batch = count
+		}
+	}
+}
+
+func WithOutboxMaxRetry(count int) func(*OutboxOptions) {
+	return func(options *OutboxOptions) {
+		if count > 0 {
+			getOutboxOptionsOrSetDefault(options).maxRetry = count
+		}
+	}
+}
+
+func WithOutboxRetryBackoff(second int) func(*OutboxOptions) {
+	return func(options *OutboxOptions) {
+		if second > 0 {
+			getOutboxOptionsOrSetDefault(options).retryBackoff = second
+		}
+	}
+}
+
+func getOutboxOptionsOrSetDefault(options *OutboxOptions) *OutboxOptions {
+	if options == nil {
+		return &OutboxOptions{
+			ctx:          context.Background(),
+			tbPrefix:     constant.MqOutboxTbPrefix,
+			lockKey:      constant.MqOutboxLockKey,
+			interval:     1000,
+			batch:        100,
+			maxRetry:     10,
+			retryBackoff: 1,
 		}
 	}
 	return options
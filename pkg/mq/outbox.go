@@ -0,0 +1,333 @@
+package mq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/golang-module/carbon/v2"
+	"github.com/piupuer/go-helper/ms"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/lock"
+	"github.com/piupuer/go-helper/pkg/log"
+	"github.com/piupuer/go-helper/pkg/utils"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// OutboxMsg msg saved with business data in the same transaction
+type OutboxMsg struct {
+	ms.M
+	Exchange    string          `gorm:"comment:exchange name" json:"exchange"`
+	RouteKey    string          `gorm:"comment:route key" json:"routeKey"`
+	ContentType string          `gorm:"comment:content type" json:"contentType"`
+	Headers     string          `gorm:"type:text;comment:headers json str" json:"headers"`
+	Body        []byte          `gorm:"type:mediumblob;comment:msg body" json:"-"`
+	Status      uint            `gorm:"type:tinyint(1);default:0;index:idx_status_next_at;comment:status(0: pending, 1: sent, 2: failed)" json:"status"`
+	NextAt      carbon.DateTime `gorm:"index:idx_status_next_at;comment:next publish time" json:"nextAt"`
+	Retry       int             `gorm:"default:0;comment:publish retry times" json:"retry"`
+	LastErr     string          `gorm:"type:text;comment:last publish error" json:"lastErr"`
+	SentAt      carbon.DateTime `gorm:"comment:sent time" json:"sentAt"`
+}
+
+type Outbox struct {
+	ops   OutboxOptions
+	rb    *Rabbit
+	Error error
+}
+
+// MigrateOutbox mysql DDL migrate rollback is not supported, Migrate before Outbox
+func MigrateOutbox(options ...func(*OutboxOptions)) (err error) {
+	ops := getOutboxOptionsOrSetDefault(nil)
+	for _, f := range options {
+		f(ops)
+	}
+	if ops.dbNoTx == nil {
+		err = errors.Errorf("db is empty")
+		return
+	}
+	err = ops.dbNoTx.
+		WithContext(ops.ctx).
+		Table(ops.tbPrefix + "outbox_msg").
+		AutoMigrate(new(OutboxMsg))
+	return
+}
+
+// Outbox msg is saved by the tx of ctx(middleware.Transaction/interceptor.Transaction), relay publishes it after commit
+func (rb *Rabbit) Outbox(options ...func(*OutboxOptions)) *Outbox {
+	var ob Outbox
+	if rb.Error != nil {
+		ob.Error = rb.Error
+		return &ob
+	}
+	ops := getOutboxOptionsOrSetDefault(nil)
+	for _, f := range options {
+		f(ops)
+	}
+	if ops.dbNoTx == nil {
+		ob.Error = errors.Errorf("db is empty")
+		return &ob
+	}
+	ob.ops = *ops
+	ob.rb = rb
+	return &ob
+}
+
+// PublishProto save grpc proto msg to outbox
+func (ob *Outbox) PublishProto(ctx context.Context, ex *Exchange, m proto.Message, options ...func(*PublishOptions)) (err error) {
+	var b []byte
+	b, err = proto.Marshal(m)
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	err = ob.PublishByte(ctx, ex, b, options...)
+	return
+}
+
+// PublishJson save str msg to outbox
+func (ob *Outbox) PublishJson(ctx context.Context, ex *Exchange, m string, options ...func(*PublishOptions)) (err error) {
+	err = ob.PublishByte(ctx, ex, []byte(m), options...)
+	return
+}
+
+// PublishByte save byte msg to outbox, only route keys/content type/headers of options are kept
+func (ob *Outbox) PublishByte(ctx context.Context, ex *Exchange, m []byte, options ...func(*PublishOptions)) (err error) {
+	if ob.Error != nil {
+		err = errors.WithStack(ob.Error)
+		return
+	}
+	if ex.Error != nil {
+		err = errors.WithStack(ex.Error)
+		return
+	}
+	if len(m) == 0 {
+		err = errors.Errorf("msg is empty")
+		return
+	}
+	ops := getPublishOptionsOrSetDefault(nil)
+	for _, f := range options {
+		f(ops)
+	}
+	if len(ops.routeKeys) == 0 {
+		err = errors.Errorf("route key is empty")
+		return
+	}
+	now := carbon.DateTime{Carbon: carbon.Now()}
+	list := make([]OutboxMsg, 0)
+	for _, key := range ops.routeKeys {
+		list = append(list, OutboxMsg{
+			Exchange:    ex.ops.name,
+			RouteKey:    key,
+			ContentType: ops.contentType,
+			Headers:     utils.Struct2Json(ops.headers),
+			Body:        m,
+			NextAt:      now,
+		})
+	}
+	var tx *gorm.DB
+	tx, err = ob.getTx(ctx)
+	if err != nil {
+		return
+	}
+	err = tx.
+		Table(ob.tableName()).
+		Create(&list).Error
+	if err != nil {
+		err = errors.Wrapf(err, "save outbox msg failed")
+	}
+	return
+}
+
+// Relay publish pending msg in background
+func (ob *Outbox) Relay() {
+	if ob.Error != nil {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case <-ob.ops.ctx.Done():
+				log.WithContext(ob.ops.ctx).Info("outbox relay stopped")
+				return
+			case <-time.After(time.Duration(ob.ops.interval) * time.Millisecond):
+			}
+			_, err := ob.RelayOnce()
+			if err != nil {
+				log.WithContext(ob.ops.ctx).WithError(err).Error("outbox relay failed")
+			}
+		}
+	}()
+}
+
+// RelayOnce publish a batch of pending msg with publisher confirms, failed msg will be retried with backoff.
+// Only one instance relays at the same time if redis is set, msg order is not guaranteed when publish failed
+func (ob *Outbox) RelayOnce() (count int, err error) {
+	if ob.Error != nil {
+		err = errors.WithStack(ob.Error)
+		return
+	}
+	// each msg is published in publish timeout at most
+	timeout := time.Duration(getPublishOptionsOrSetDefault(nil).timeout) * time.Millisecond
+	expiration := time.Duration(ob.ops.batch)*timeout + time.Minute
+	start := time.Now()
+	if ob.ops.redis != nil {
+		nxLock := lock.NxLock{
+			Key:        ob.ops.lockKey,
+			Redis:      ob.ops.redis,
+			Expiration: expiration,
+		}
+		if !nxLock.Lock() {
+			// other instance is relaying
+			return
+		}
+		defer nxLock.Unlock()
+	}
+	list := make([]OutboxMsg, 0)
+	err = ob.session().
+		Where("status = ?", constant.MqOutboxStatusPending).
+		Where("next_at <= ?", carbon.DateTime{Carbon: carbon.Now()}).
+		Order("id").
+		Limit(ob.ops.batch).
+		Find(&list).Error
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+	for i, item := range list {
+		if time.Since(start)+timeout > expiration {
+			// lock may expire before the next publish, other instance will relay the rest
+			log.WithContext(ob.ops.ctx).Warn("outbox relay lock is about to expire, %d msg left", len(list)-i)
+			break
+		}
+		m := make(map[string]interface{})
+		e := ob.publish(item)
+		if e == nil {
+			m["status"] = constant.MqOutboxStatusSent
+			m["sent_at"] = carbon.DateTime{Carbon: carbon.Now()}
+			count++
+		} else {
+			retry := item.Retry + 1
+			m["retry"] = retry
+			m["last_err"] = e.Error()
+			m["next_at"] = carbon.DateTime{Carbon: carbon.Now().AddSeconds(ob.getBackoff(retry))}
+			if retry >= ob.ops.maxRetry {
+				m["status"] = constant.MqOutboxStatusFailed
+			}
+			log.WithContext(ob.ops.ctx).WithError(e).Warn("outbox msg %d publish failed, retry %d", item.Id, retry)
+		}
+		e = ob.session().
+			Where("id = ?", item.Id).
+			Updates(m).Error
+		if e != nil {
+			log.WithContext(ob.ops.ctx).WithError(e).Error("outbox msg %d update failed", item.Id)
+		}
+	}
+	return
+}
+
+func (ob *Outbox) publish(item OutboxMsg) (err error) {
+	headers := make(amqp.Table)
+	if item.Headers != "" {
+		headers, err = decodeOutboxHeaders(item.Headers)
+		if err != nil {
+			return
+		}
+	}
+	ex := &Exchange{
+		ops: ExchangeOptions{
+			name: item.Exchange,
+		},
+		rb: ob.rb,
+	}
+	options := []func(*PublishOptions){
+		WithPublishCtx(ob.ops.ctx),
+		WithPublishRouteKey(item.RouteKey),
+		WithPublishHeaders(headers),
+		WithPublishConfirm(true),
+		// the same msg id if relay publishes it again, consumer dedup can skip it
+		WithPublishProperties(amqp.Publishing{
+			MessageId: fmt.Sprintf("%s.%d", ob.tableName(), item.Id),
+		}),
+	}
+	if item.ContentType != "" {
+		options = append(options, WithPublishContentType(item.ContentType))
+	}
+	err = ex.PublishByte(item.Body, options...)
+	return
+}
+
+// decode headers json str, numbers are kept as int64(or float64) instead of float64 only
+func decodeOutboxHeaders(str string) (headers amqp.Table, err error) {
+	m := make(map[string]interface{})
+	d := json.NewDecoder(strings.NewReader(str))
+	d.UseNumber()
+	err = d.Decode(&m)
+	if err != nil {
+		err = errors.Wrapf(err, "decode outbox headers failed")
+		return
+	}
+	headers = convertOutboxHeader(m).(amqp.Table)
+	return
+}
+
+// json number to int64/float64, json object to amqp.Table(map is not a valid amqp field type)
+func convertOutboxHeader(v interface{}) interface{} {
+	switch item := v.(type) {
+	case json.Number:
+		if i, err := item.Int64(); err == nil {
+			return i
+		}
+		f, _ := item.Float64()
+		return f
+	case map[string]interface{}:
+		t := make(amqp.Table, len(item))
+		for k, val := range item {
+			t[k] = convertOutboxHeader(val)
+		}
+		return t
+	case []interface{}:
+		for i, val := range item {
+			item[i] = convertOutboxHeader(val)
+		}
+		return item
+	}
+	return v
+}
+
+// backoff seconds of retry: backoff * 2^(retry-1)
+func (ob *Outbox) getBackoff(retry int) int {
+	backoff := ob.ops.retryBackoff
+	for i := 1; i < retry && backoff < constant.MqOutboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > constant.MqOutboxMaxBackoff {
+		backoff = constant.MqOutboxMaxBackoff
+	}
+	return backoff
+}
+
+// outbox msg must be saved with business data, never fall back to db without tx
+func (ob *Outbox) getTx(ctx context.Context) (tx *gorm.DB, err error) {
+	if ctx != nil {
+		if item, ok := ctx.Value(constant.MiddlewareTransactionTxCtxKey).(*gorm.DB); ok && item != nil {
+			tx = item
+			return
+		}
+	}
+	err = errors.Errorf("tx of ctx is empty, use middleware.Transaction or interceptor.Transaction")
+	return
+}
+
+func (ob *Outbox) session() *gorm.DB {
+	return ob.ops.dbNoTx.
+		WithContext(ob.ops.ctx).
+		Table(ob.tableName())
+}
+
+func (ob *Outbox) tableName() string {
+	return ob.ops.tbPrefix + "outbox_msg"
+}
@@ -1,6 +1,7 @@
 package mq
 
 import (
+	"context"
 	"github.com/google/uuid"
 	"github.com/houseofcat/turbocookedrabbit/v2/pkg/tcr"
 	"github.com/pkg/errors"
@@ -10,6 +11,12 @@ import (
 	"time"
 )
 
+var (
+	ErrPublishNack           = errors.New("publish nacked by broker")
+	ErrPublishReturned       = errors.New("publish returned as unroutable")
+	ErrPublishConfirmTimeout = errors.New("publish confirm timeout")
+)
+
 type Publish struct {
 	ops       PublishOptions
 	ex        *Exchange
@@ -36,6 +43,31 @@ func (ex *Exchange) PublishJson(m string, options ...func(*PublishOptions)) (err
 	return
 }
 
+// PublishBatch publish msgs on one channel and wait for confirms of the whole batch(confirm mode is always on)
+func (ex *Exchange) PublishBatch(ms [][]byte, options ...func(*PublishOptions)) (err error) {
+	if len(ms) == 0 {
+		err = errors.Errorf("msg is empty")
+		return
+	}
+	for _, item := range ms {
+		if len(item) == 0 {
+			err = errors.Errorf("msg is empty")
+			return
+		}
+	}
+	pu := ex.beforePublish(append(options, WithPublishConfirm(true))...)
+	if pu.Error != nil {
+		err = errors.WithStack(pu.Error)
+		return
+	}
+	if atomic.LoadInt32(&pu.ex.rb.lost) == 1 {
+		err = errors.Errorf("connection maybe lost")
+		return
+	}
+	err = pu.publishWithConfirm(ms)
+	return
+}
+
 // PublishByte publish byte msg
 func (ex *Exchange) PublishByte(m []byte, options ...func(*PublishOptions)) (err error) {
 	if len(m) == 0 {
@@ -91,6 +123,18 @@ func (ex *Exchange) beforePublish(options ...func(*PublishOptions)) *Publish {
 		Headers:      ops.headers,
 		Expiration:   ops.expiration,
 	}
+	if p := ops.properties; p != nil {
+		msg.MessageId = p.MessageId
+		msg.CorrelationId = p.CorrelationId
+		msg.ReplyTo = p.ReplyTo
+		msg.Priority = p.Priority
+		msg.Type = p.Type
+		msg.AppId = p.AppId
+		msg.UserId = p.UserId
+		if !p.Timestamp.IsZero() {
+			msg.Timestamp = p.Timestamp
+		}
+	}
 	pu.msg = msg
 	pu.ex = ex
 	pu.publisher = tcr.NewPublisherFromConfig(
@@ -113,6 +157,14 @@ func (pu *Publish) publish() (err error) {
 		err = errors.Errorf("connection maybe lost")
 		return
 	}
+	if pu.ops.confirm {
+		err = pu.publishWithConfirm([][]byte{pu.msg.Body})
+		return
+	}
+	if pu.ops.properties != nil {
+		err = pu.publishWithProperties()
+		return
+	}
 	for _, key := range pu.ops.routeKeys {
 		envelope := &tcr.Envelope{
 			DeliveryMode: pu.msg.DeliveryMode,
@@ -140,3 +192,79 @@ func (pu *Publish) publish() (err error) {
 	}
 	return
 }
+
+// tcr letter has no properties(MessageId/CorrelationId...), publish msg on a pool channel without confirm
+func (pu *Publish) publishWithProperties() (err error) {
+	ch := pu.ex.rb.pool.GetChannelFromPool()
+	defer func() {
+		pu.ex.rb.pool.ReturnChannel(ch, err != nil)
+	}()
+	for _, key := range pu.ops.routeKeys {
+		err = ch.Channel.Publish(pu.ex.ops.name, key, pu.ops.mandatory, pu.ops.immediate, pu.msg)
+		if err != nil {
+			err = errors.Wrapf(err, "publish failed")
+			return
+		}
+	}
+	return
+}
+
+// publish each msg to each route key on a confirm channel, then wait for all confirms
+func (pu *Publish) publishWithConfirm(bodies [][]byte) (err error) {
+	ctx, cancel := context.WithTimeout(pu.ops.ctx, time.Duration(pu.ops.timeout)*time.Millisecond)
+	defer cancel()
+	ch := pu.ex.rb.pool.GetTransientChannel(true)
+	defer ch.Close()
+	total := len(bodies) * len(pu.ops.routeKeys)
+	// buffered, the channel will be blocked if nobody receives
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, total))
+	returns := ch.NotifyReturn(make(chan amqp.Return, total))
+	for _, body := range bodies {
+		for _, key := range pu.ops.routeKeys {
+			msg := pu.msg
+			msg.Body = body
+			if msg.MessageId == "" {
+				msg.MessageId = uuid.NewString()
+			}
+			err = ch.Publish(pu.ex.ops.name, key, true, false, msg)
+			if err != nil {
+				err = errors.Wrapf(err, "publish failed")
+				return
+			}
+		}
+	}
+	nacked := 0
+	for i := 0; i < total; i++ {
+		select {
+		case <-ctx.Done():
+			err = errors.Wrapf(ErrPublishConfirmTimeout, "%d/%d confirmed", i, total)
+			return
+		case c, ok := <-confirms:
+			if !ok {
+				err = errors.Wrapf(ErrPublishConfirmTimeout, "channel closed, %d/%d confirmed", i, total)
+				return
+			}
+			if !c.Ack {
+				nacked++
+			}
+		}
+	}
+	// broker sends return before ack, all returns have arrived
+	list := make([]amqp.Return, 0)
+	for len(returns) > 0 {
+		list = append(list, <-returns)
+	}
+	if len(list) > 0 {
+		if pu.ops.returnHandler == nil {
+			err = errors.Wrapf(ErrPublishReturned, "%d/%d returned, reply: %s", len(list), total, list[0].ReplyText)
+			return
+		}
+		for _, item := range list {
+			pu.ops.returnHandler(pu.ops.ctx, item)
+		}
+	}
+	if nacked > 0 {
+		err = errors.Wrapf(ErrPublishNack, "%d/%d nacked", nacked, total)
+	}
+	return
+}
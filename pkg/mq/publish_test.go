@@ -3,17 +3,20 @@ package mq
 import (
 	"context"
 	"fmt"
+	"github.com/go-redis/redis/v8"
 	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
 	"testing"
 	"time"
 )
 
 func TestExchange_PublishProto(t *testing.T) {
-	rb := NewRabbit(
-		uri,
-		WithReconnectMaxRetryCount(3),
-	)
+	skipIfUnreachable(t, rabbitAddr)
+	rb := NewRabbit(uri)
 	if rb.Error != nil {
 		panic(rb.Error)
 	}
@@ -25,7 +28,7 @@ func TestExchange_PublishProto(t *testing.T) {
 		panic(ex.Error)
 	}
 
-	for {
+	for i := 0; i < 10; i++ {
 		time.Sleep(100 * time.Millisecond)
 		go func() {
 			ctx := context.WithValue(context.Background(), constant.MiddlewareRequestIdCtxKey, "send-1")
@@ -64,10 +67,8 @@ func TestExchange_PublishProto(t *testing.T) {
 }
 
 func TestExchange_PublishProto2(t *testing.T) {
-	rb := NewRabbit(
-		uri,
-		WithReconnectMaxRetryCount(3),
-	)
+	skipIfUnreachable(t, rabbitAddr)
+	rb := NewRabbit(uri)
 	if rb.Error != nil {
 		panic(rb.Error)
 	}
@@ -79,7 +80,7 @@ func TestExchange_PublishProto2(t *testing.T) {
 		panic(ex.Error)
 	}
 
-	for {
+	for i := 0; i < 3; i++ {
 		time.Sleep(time.Second)
 		var mqPb emptypb.Empty
 		err := ex.PublishProto(
@@ -89,3 +90,125 @@ func TestExchange_PublishProto2(t *testing.T) {
 		fmt.Println(time.Now(), "send end", err)
 	}
 }
+
+func TestOutbox_PublishProto(t *testing.T) {
+	skipIfUnreachable(t, rabbitAddr, redisAddr, mysqlAddr)
+	db, _ := gorm.Open(mysql.Open("root:root@tcp(127.0.0.1:4306)/gin_web?charset=utf8mb4&collation=utf8mb4_general_ci&parseTime=True&loc=Local&timeout=10000ms"), &gorm.Config{})
+	MigrateOutbox(
+		WithOutboxDbNoTx(db),
+	)
+	rb := NewRabbit(uri)
+	if rb.Error != nil {
+		panic(rb.Error)
+	}
+	ex := rb.Exchange(
+		WithExchangeName("ex1"),
+		WithExchangeDeclare(false),
+	)
+	ob := rb.Outbox(
+		WithOutboxDbNoTx(db),
+		WithOutboxRedis(redis.NewClient(&redis.Options{
+			Addr: redisAddr,
+		})),
+	)
+	// save msg with business data in one transaction
+	tx := db.Begin()
+	ctx := context.WithValue(context.Background(), constant.MiddlewareTransactionTxCtxKey, tx)
+	var mqPb emptypb.Empty
+	err := ob.PublishProto(
+		ctx,
+		ex,
+		&mqPb,
+		WithPublishRouteKey("rt1"),
+	)
+	fmt.Println(err)
+	tx.Commit()
+	fmt.Println(ob.RelayOnce())
+}
+
+func TestExchange_PublishConfirm(t *testing.T) {
+	skipIfUnreachable(t, rabbitAddr)
+	rb := NewRabbit(uri)
+	if rb.Error != nil {
+		panic(rb.Error)
+	}
+	ex := rb.Exchange(
+		WithExchangeName("ex1"),
+		WithExchangeDeclare(false),
+	)
+	if ex.Error != nil {
+		panic(ex.Error)
+	}
+	// wait for broker ack
+	err := ex.PublishJson(
+		`{"id":1}`,
+		WithPublishRouteKey("rt1"),
+		WithPublishConfirm(true),
+		WithPublishTimeout(3000),
+	)
+	fmt.Println(err)
+	// no queue bound to rt-unknown
+	err = ex.PublishJson(
+		`{"id":2}`,
+		WithPublishRouteKey("rt-unknown"),
+		WithPublishConfirm(true),
+	)
+	fmt.Println(errors.Is(err, ErrPublishReturned), err)
+	err = ex.PublishBatch(
+		[][]byte{[]byte(`{"id":3}`), []byte(`{"id":4}`)},
+		WithPublishRouteKey("rt1", "rt-unknown"),
+		WithPublishReturnHandler(func(ctx context.Context, r amqp.Return) {
+			fmt.Println("returned", r.RoutingKey, r.ReplyText, string(r.Body))
+		}),
+	)
+	fmt.Println(err)
+}
+
+func TestOutbox_getBackoff(t *testing.T) {
+	ob := Outbox{
+		ops: OutboxOptions{
+			retryBackoff: 5,
+		},
+	}
+	cases := map[int]int{
+		1:  5,
+		2:  10,
+		3:  20,
+		7:  320,
+		8:  constant.MqOutboxMaxBackoff,
+		20: constant.MqOutboxMaxBackoff,
+	}
+	for retry, want := range cases {
+		if got := ob.getBackoff(retry); got != want {
+			t.Errorf("getBackoff(%d) = %d, want %d", retry, got, want)
+		}
+	}
+}
+
+func TestDecodeOutboxHeaders(t *testing.T) {
+	headers, err := decodeOutboxHeaders(`{"x-retry-attempt":2,"rate":1.5,"name":"a","nested":{"n":3},"list":[1,"b"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempt := getRetryAttempt(headers); attempt != 2 {
+		t.Errorf("getRetryAttempt = %d, want 2", attempt)
+	}
+	if v, ok := headers["rate"].(float64); !ok || v != 1.5 {
+		t.Errorf("rate = %v(%T), want 1.5", headers["rate"], headers["rate"])
+	}
+	if v, ok := headers["name"].(string); !ok || v != "a" {
+		t.Errorf("name = %v, want a", headers["name"])
+	}
+	if v, ok := headers["nested"].(amqp.Table); !ok || v["n"] != int64(3) {
+		t.Errorf("nested = %v(%T), want amqp.Table with int64", headers["nested"], headers["nested"])
+	}
+	if v, ok := headers["list"].([]interface{}); !ok || v[0] != int64(1) {
+		t.Errorf("list = %v, want int64 item", headers["list"])
+	}
+	if err = headers.Validate(); err != nil {
+		t.Error(err)
+	}
+	if _, err = decodeOutboxHeaders(`{`); err == nil {
+		t.Error("invalid json should fail")
+	}
+}
@@ -174,6 +174,13 @@ func (ex *Exchange) Queue(options ...func(*QueueOptions)) *Queue {
 			return qu
 		}
 	}
+	if qu.ops.declare && qu.ops.retry > 0 {
+		err := qu.declareRetry()
+		if err != nil {
+			qu.Error = errors.WithStack(err)
+			return qu
+		}
+	}
 	return qu
 }
 
@@ -0,0 +1,188 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+// declare retry topology: q.retry.1...q.retry.n delay queues and q.parking
+// msg in retry queue expires by ttl then routes back to q by default exchange
+func (qu *Queue) declareRetry() (err error) {
+	ch := qu.ex.rb.pool.GetChannelFromPool()
+	defer func() {
+		qu.ex.rb.pool.ReturnChannel(ch, true)
+	}()
+	var i int32
+	for i = 1; i <= qu.ops.retry; i++ {
+		name := qu.getRetryQueueName(i)
+		if _, err = ch.Channel.QueueDeclare(
+			name,
+			qu.ops.durable,
+			false,
+			false,
+			qu.ops.noWait,
+			amqp.Table{
+				"x-message-ttl":             qu.getRetryTTL(i),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": qu.ops.name,
+			},
+		); err != nil {
+			err = errors.Wrapf(err, "failed to declare retry queue %s", name)
+			return
+		}
+	}
+	name := qu.ops.name + constant.MqParkingQueueSuffix
+	if _, err = ch.Channel.QueueDeclare(
+		name,
+		qu.ops.durable,
+		false,
+		false,
+		qu.ops.noWait,
+		nil,
+	); err != nil {
+		err = errors.Wrapf(err, "failed to declare parking queue %s", name)
+		return
+	}
+	return
+}
+
+// retry republish failed msg to retry queue of next attempt(or parking queue), then ack it
+func (qu *Queue) retry(ctx context.Context, d amqp.Delivery, requeue bool) {
+	attempt := getRetryAttempt(d.Headers) + 1
+	target := qu.getRetryQueueName(attempt)
+	if attempt > qu.ops.retry {
+		target = qu.ops.name + constant.MqParkingQueueSuffix
+		log.WithContext(ctx).Warn("maximum retry %d exceeded, park msg to %s", qu.ops.retry, target)
+	}
+	headers := make(amqp.Table)
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[constant.MqRetryAttemptHeader] = attempt
+	qu.republish(ctx, d, target, headers, requeue)
+}
+
+// delay republish msg to be redelivered later without counting an attempt:
+// retry queue of current attempt is used if retry is on, otherwise a ttl queue q.dedup
+func (qu *Queue) delay(ctx context.Context, d amqp.Delivery, requeue bool) {
+	var target string
+	if qu.ops.retry > 0 {
+		attempt := getRetryAttempt(d.Headers)
+		if attempt < 1 {
+			attempt = 1
+		}
+		if attempt > qu.ops.retry {
+			attempt = qu.ops.retry
+		}
+		target = qu.getRetryQueueName(attempt)
+	} else {
+		target = qu.ops.name + constant.MqDedupQueueSuffix
+		err := qu.declareDelay(target)
+		if err != nil {
+			log.WithContext(ctx).WithError(err).Error("consume delay declare failed")
+			if e := d.Acknowledger.Nack(d.DeliveryTag, false, requeue); e != nil {
+				log.WithContext(ctx).WithError(e).Error("consume delay nack failed")
+			}
+			return
+		}
+	}
+	qu.republish(ctx, d, target, d.Headers, requeue)
+}
+
+// declare ttl queue, msg expires then routes back to q by default exchange
+func (qu *Queue) declareDelay(name string) (err error) {
+	ch := qu.ex.rb.pool.GetChannelFromPool()
+	defer func() {
+		qu.ex.rb.pool.ReturnChannel(ch, true)
+	}()
+	if _, err = ch.Channel.QueueDeclare(
+		name,
+		qu.ops.durable,
+		false,
+		false,
+		qu.ops.noWait,
+		amqp.Table{
+			"x-message-ttl":             int32(constant.MqDedupRequeueDelay),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": qu.ops.name,
+		},
+	); err != nil {
+		err = errors.Wrapf(err, "failed to declare delay queue %s", name)
+	}
+	return
+}
+
+// republish msg to target queue with its properties, ack it if success, otherwise nack it
+func (qu *Queue) republish(ctx context.Context, d amqp.Delivery, target string, headers amqp.Table, requeue bool) {
+	options := []func(*PublishOptions){
+		WithPublishCtx(ctx),
+		WithPublishHeaders(headers),
+		WithPublishRouteKey(target),
+		// original msg will be acked, the republished one must be confirmed
+		WithPublishConfirm(true),
+		// keep msg id for dedup, and other properties of the original msg
+		WithPublishProperties(amqp.Publishing{
+			MessageId:     d.MessageId,
+			CorrelationId: d.CorrelationId,
+			ReplyTo:       d.ReplyTo,
+			Priority:      d.Priority,
+			Type:          d.Type,
+			AppId:         d.AppId,
+			UserId:        d.UserId,
+			Timestamp:     d.Timestamp,
+		}),
+	}
+	if d.ContentType != "" {
+		options = append(options, WithPublishContentType(d.ContentType))
+	}
+	// default exchange routes msg to the queue whose name is route key
+	ex := &Exchange{
+		rb: qu.ex.rb,
+	}
+	err := ex.PublishByte(d.Body, options...)
+	if err != nil {
+		log.WithContext(ctx).WithError(err).Error("consume republish to %s failed", target)
+		e := d.Acknowledger.Nack(d.DeliveryTag, false, requeue)
+		if e != nil {
+			log.WithContext(ctx).WithError(e).Error("consume republish nack failed")
+		}
+		return
+	}
+	e := d.Acknowledger.Ack(d.DeliveryTag, false)
+	if e != nil {
+		log.WithContext(ctx).WithError(e).Error("consume republish ack failed")
+	}
+}
+
+func (qu *Queue) getRetryQueueName(attempt int32) string {
+	return fmt.Sprintf("%s%s%d", qu.ops.name, constant.MqRetryQueueSuffix, attempt)
+}
+
+// delay of attempt: backoff * 2^(attempt-1), max is retryMaxBackoff
+func (qu *Queue) getRetryTTL(attempt int32) int32 {
+	ttl := int64(qu.ops.retryBackoff)
+	max := int64(qu.ops.retryMaxBackoff)
+	for i := int32(1); i < attempt && ttl < max; i++ {
+		ttl *= 2
+	}
+	if ttl > max {
+		ttl = max
+	}
+	return int32(ttl)
+}
+
+func getRetryAttempt(headers amqp.Table) (attempt int32) {
+	switch v := headers[constant.MqRetryAttemptHeader].(type) {
+	case int32:
+		attempt = v
+	case int64:
+		attempt = int32(v)
+	case int:
+		attempt = int32(v)
+	}
+	return
+}
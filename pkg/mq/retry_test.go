@@ -0,0 +1,47 @@
+package mq
+
+import (
+	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+func TestQueue_getRetryTTL(t *testing.T) {
+	qu := Queue{
+		ops: QueueOptions{
+			retryBackoff:    1000,
+			retryMaxBackoff: 5000,
+		},
+	}
+	cases := map[int32]int32{
+		1: 1000,
+		2: 2000,
+		3: 4000,
+		4: 5000,
+		9: 5000,
+	}
+	for attempt, want := range cases {
+		if got := qu.getRetryTTL(attempt); got != want {
+			t.Errorf("getRetryTTL(%d) = %d, want %d", attempt, got, want)
+		}
+	}
+}
+
+func TestGetRetryAttempt(t *testing.T) {
+	cases := []struct {
+		headers amqp.Table
+		want    int32
+	}{
+		{nil, 0},
+		{amqp.Table{}, 0},
+		{amqp.Table{constant.MqRetryAttemptHeader: int32(2)}, 2},
+		{amqp.Table{constant.MqRetryAttemptHeader: int64(3)}, 3},
+		{amqp.Table{constant.MqRetryAttemptHeader: 4}, 4},
+		{amqp.Table{constant.MqRetryAttemptHeader: "5"}, 0},
+	}
+	for _, item := range cases {
+		if got := getRetryAttempt(item.headers); got != item.want {
+			t.Errorf("getRetryAttempt(%v) = %d, want %d", item.headers, got, item.want)
+		}
+	}
+}
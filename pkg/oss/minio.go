@@ -101,6 +101,17 @@ func (mo *MinioOss) GetPreviewUrl(ctx context.Context, bucketName, objectName st
 	return
 }
 
+// GetPresignedUrl download url which will be expired
+func (mo *MinioOss) GetPresignedUrl(ctx context.Context, bucketName, objectName string, expire time.Duration) (rp string, err error) {
+	var u *url.URL
+	u, err = mo.client.PresignedGetObject(ctx, bucketName, objectName, expire, url.Values{})
+	if err != nil {
+		return
+	}
+	rp = u.String()
+	return
+}
+
 func (mo *MinioOss) Exists(ctx context.Context, bucketName, objectName string) (ok bool) {
 	_, err := mo.client.StatObject(ctx, bucketName, objectName, minio.StatObjectOptions{})
 	ok = err == nil
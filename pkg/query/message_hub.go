@@ -150,6 +150,15 @@ func (h *MessageHub) SendToUserIds(userIds []uint, msg resp.MessageWs) {
 	}
 }
 
+func (h *MessageHub) SendToRoleIds(roleIds []uint, msg resp.MessageWs) {
+	for _, client := range h.getClients() {
+		// Notify users of the specified role
+		if utils.ContainsUint(roleIds, client.User.RoleId) {
+			client.Send.SafeSend(msg)
+		}
+	}
+}
+
 func (h *MessageHub) run() {
 	for {
 		select {
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/fsm"
 	"github.com/piupuer/go-helper/pkg/log"
 	"github.com/piupuer/go-helper/pkg/resp"
 	"github.com/piupuer/go-helper/pkg/tracing"
@@ -39,6 +40,9 @@ func NewMySql(options ...func(*MysqlOptions)) MySql {
 	my.Tx = tx.WithContext(rc)
 	my.Db = ops.db.WithContext(rc)
 	my.ops = *ops
+	if ops.fsmNotify {
+		my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithNotify(my.FsmNotify(ops.messageHub)))
+	}
 	return my
 }
 
@@ -61,6 +65,18 @@ func (my MySql) ForceCommit() {
 	}
 }
 
+// AfterCommit run fun after the transaction of ctx(middleware.Transaction/interceptor.Transaction) commits,
+// fun is dropped if it rolls back, run at once without transaction
+func (my MySql) AfterCommit(fun func()) {
+	if my.ops.ctx != nil {
+		if list, ok := my.ops.ctx.Value(constant.MiddlewareTransactionAfterCommitCtxKey).(*[]func()); ok && list != nil {
+			*list = append(*list, fun)
+			return
+		}
+	}
+	fun()
+}
+
 func (my MySql) GetById(id uint, model interface{}, options ...func(*MysqlReadOptions)) {
 	my.FindByColumns(id, model, options...)
 }
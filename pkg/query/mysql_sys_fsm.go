@@ -1,8 +1,12 @@
 package query
 
 import (
+	"context"
+	"github.com/piupuer/go-helper/ms"
 	"github.com/piupuer/go-helper/pkg/constant"
+	"github.com/piupuer/go-helper/pkg/delay"
 	"github.com/piupuer/go-helper/pkg/fsm"
+	"github.com/piupuer/go-helper/pkg/i18n"
 	"github.com/piupuer/go-helper/pkg/req"
 	"github.com/piupuer/go-helper/pkg/resp"
 	"github.com/piupuer/go-helper/pkg/tracing"
@@ -17,6 +21,48 @@ func (my MySql) FindFsm(r *req.FsmMachine) []resp.FsmMachine {
 	return f.FindMachine(r)
 }
 
+// FindFsmVersion find finite state machine versions
+func (my MySql) FindFsmVersion(id uint) []resp.FsmMachine {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "FindFsmVersion"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	return f.FindMachineVersion(id)
+}
+
+// DiffFsmVersion compare two finite state machine versions
+func (my MySql) DiffFsmVersion(r req.FsmMachineVersionDiff) (rp resp.FsmMachineVersionDiff, err error) {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "DiffFsmVersion"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	rp = f.DiffMachineVersion(r)
+	err = f.Error
+	return
+}
+
+// GetFsmGraph render finite state machine as dot/mermaid
+func (my MySql) GetFsmGraph(r req.FsmMachineGraph) (rp resp.FsmMachineGraph, err error) {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "GetFsmGraph"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	rp = f.GetMachineGraph(r)
+	err = f.Error
+	return
+}
+
+// GetFsmAnalytics finite state machine approval reports
+func (my MySql) GetFsmAnalytics(r req.FsmAnalytics) (rp resp.FsmAnalytics, err error) {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "GetFsmAnalytics"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	rp = f.GetAnalytics(r)
+	err = f.Error
+	return
+}
+
 // FindFsmApprovingLog find waiting approve log
 func (my MySql) FindFsmApprovingLog(r *req.FsmPendingLog) []resp.FsmApprovingLog {
 	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "FindFsmApprovingLog"))
@@ -49,6 +95,25 @@ func (my MySql) FindFsmLogTrack(r req.FsmLog) (tracks []resp.FsmLogTrack) {
 	return
 }
 
+// GetFsmLogSubmitterDetail get submitter detail saved by the last log
+func (my MySql) GetFsmLogSubmitterDetail(r req.FsmLogSubmitterDetail) (detail string) {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "GetFsmLogSubmitterDetail"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	var id uint
+	for _, item := range f.FindLog(req.FsmLog{
+		Category: r.Category,
+		Uuid:     r.Uuid,
+	}) {
+		if item.Id > id {
+			id = item.Id
+			detail = item.SubmitterDetail
+		}
+	}
+	return
+}
+
 // FsmSubmitLog submit log
 func (my MySql) FsmSubmitLog(r req.FsmCreateLog) (err error) {
 	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "FsmSubmitLog"))
@@ -56,6 +121,7 @@ func (my MySql) FsmSubmitLog(r req.FsmCreateLog) (err error) {
 	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
 	f := fsm.New(my.ops.fsmOps...)
 	f.SubmitLog(r)
+	my.flushFsm(f)
 	return f.Error
 }
 
@@ -66,6 +132,30 @@ func (my MySql) FsmApproveLog(r req.FsmApproveLog) (err error) {
 	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
 	f := fsm.New(my.ops.fsmOps...)
 	f.ApproveLog(r)
+	my.flushFsm(f)
+	return f.Error
+}
+
+// FsmBatchApproveLog batch approve finite state machine logs
+func (my MySql) FsmBatchApproveLog(r req.FsmBatchApproveLog) (rp []resp.FsmBatchApprovalLog, err error) {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "FsmBatchApproveLog"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	rp = f.BatchApproveLog(r)
+	my.flushFsm(f)
+	err = f.Error
+	return
+}
+
+// FsmAddSignLog add ad-hoc approvers to finite state machine log
+func (my MySql) FsmAddSignLog(r req.FsmAddSignLog) error {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "FsmAddSignLog"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	f.AddSignLog(r)
+	my.flushFsm(f)
 	return f.Error
 }
 
@@ -76,9 +166,22 @@ func (my MySql) FsmCancelLogByUuids(r req.FsmCancelLog) error {
 	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
 	f := fsm.New(my.ops.fsmOps...)
 	f.CancelLogByUuids(r)
+	my.flushFsm(f)
 	return f.Error
 }
 
+// FsmProcessTask process finite state machine remind/timeout delay task
+func (my MySql) FsmProcessTask(t delay.Task) (match bool, err error) {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "FsmProcessTask"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	match = f.ProcessTask(t)
+	my.flushFsm(f)
+	err = f.Error
+	return
+}
+
 // FsmCheckEditLogDetailPermission check edit log detail permission
 func (my MySql) FsmCheckEditLogDetailPermission(r req.FsmCheckEditLogDetailPermission) error {
 	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "FsmCheckEditLogDetailPermission"))
@@ -89,6 +192,16 @@ func (my MySql) FsmCheckEditLogDetailPermission(r req.FsmCheckEditLogDetailPermi
 	return f.Error
 }
 
+// FsmEditLogDetail check edit log detail permission and save detail diff
+func (my MySql) FsmEditLogDetail(r req.FsmCheckEditLogDetailPermission) error {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "FsmEditLogDetail"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	f.EditLogDetail(r)
+	return f.Error
+}
+
 // CreateFsm create finite state machine
 func (my MySql) CreateFsm(r req.FsmCreateMachine) error {
 	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "CreateFsm"))
@@ -106,6 +219,7 @@ func (my MySql) UpdateFsmById(id uint, r req.FsmUpdateMachine) error {
 	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
 	f := fsm.New(my.ops.fsmOps...)
 	f.UpdateMachineById(id, r)
+	my.flushFsm(f)
 	return f.Error
 }
 
@@ -116,5 +230,103 @@ func (my MySql) DeleteFsmByIds(ids []uint) error {
 	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
 	f := fsm.New(my.ops.fsmOps...)
 	f.DeleteMachineByIds(ids)
+	my.flushFsm(f)
 	return f.Error
 }
+
+// CreateFsmDelegation create finite state machine approval delegation
+func (my MySql) CreateFsmDelegation(r req.FsmCreateDelegation) error {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "CreateFsmDelegation"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	f.CreateDelegation(r)
+	return f.Error
+}
+
+// FindFsmDelegation find finite state machine approval delegation
+func (my MySql) FindFsmDelegation(r *req.FsmDelegation) []resp.FsmDelegation {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "FindFsmDelegation"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	return f.FindDelegation(r)
+}
+
+// DeleteFsmDelegationByIds delete finite state machine approval delegation
+func (my MySql) DeleteFsmDelegationByIds(ids []uint, fromUserId uint) error {
+	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "DeleteFsmDelegationByIds"))
+	defer span.End()
+	my.ops.fsmOps = append(my.ops.fsmOps, fsm.WithCtx(my.Ctx), fsm.WithDb(my.Tx))
+	f := fsm.New(my.ops.fsmOps...)
+	f.DeleteDelegationByIds(ids, fromUserId)
+	return f.Error
+}
+
+// run remind/timeout tasks and msg push of fsm after the transaction commits, rolled back approval has no side effect
+func (my MySql) flushFsm(f *fsm.Fsm) {
+	if f.Error != nil {
+		return
+	}
+	my.AfterCommit(f.Flush)
+}
+
+// FsmNotify save fsm notification as one2one(users)/one2many(roles) messages, push by message hub(if it is not nil) after the approval commits
+func (my MySql) FsmNotify(hub *MessageHub) func(ctx context.Context, n fsm.Notification) error {
+	return func(ctx context.Context, n fsm.Notification) error {
+		_, span := tracer.Start(ctx, tracing.Name(tracing.Db, "FsmNotify"))
+		defer span.End()
+		title := i18n.TWithData(constant.FsmNotifyMsgTitle, n)
+		// approvers need to approve
+		err := my.sendFsmMessage(ctx, hub, ms.SysMessage{
+			FromUserId: n.FromUserId,
+			Title:      title,
+			Content:    i18n.TWithData(constant.FsmNotifyMsgReach, n),
+		}, n.ApproverUserIds, n.ApproverRoleIds)
+		if err != nil {
+			return err
+		}
+		content := i18n.TWithData(constant.FsmNotifyMsgCc, n)
+		switch n.Action {
+		case constant.FsmNotifyPass:
+			content = i18n.TWithData(constant.FsmNotifyMsgPass, n)
+		case constant.FsmNotifyReject:
+			content = i18n.TWithData(constant.FsmNotifyMsgReject, n)
+		}
+		return my.sendFsmMessage(ctx, hub, ms.SysMessage{
+			FromUserId: n.FromUserId,
+			Title:      title,
+			Content:    content,
+		}, n.CcUserIds, n.CcRoleIds)
+	}
+}
+
+func (my MySql) sendFsmMessage(ctx context.Context, hub *MessageHub, message ms.SysMessage, userIds, roleIds []uint) (err error) {
+	msg := resp.MessageWs{
+		Type:   MessageRespNormal,
+		Detail: resp.GetSuccessWithData(message),
+	}
+	if len(userIds) > 0 {
+		err = my.BatchCreateOneToOneMessage(message, userIds)
+		if err != nil {
+			return
+		}
+		if hub != nil {
+			fsm.AfterCommit(ctx, func() {
+				hub.SendToUserIds(userIds, msg)
+			})
+		}
+	}
+	if len(roleIds) > 0 {
+		err = my.BatchCreateOneToManyMessage(message, roleIds)
+		if err != nil {
+			return
+		}
+		if hub != nil {
+			fsm.AfterCommit(ctx, func() {
+				hub.SendToRoleIds(roleIds, msg)
+			})
+		}
+	}
+	return
+}
@@ -173,14 +173,14 @@ func (my MySql) CreateMessage(r *req.PushMessage) (err error) {
 				err = errors.Errorf("to user is empty")
 				return
 			}
-			my.BatchCreateOneToOneMessage(message, r.ToUserIds)
+			err = my.BatchCreateOneToOneMessage(message, r.ToUserIds)
 			return
 		case ms.SysMessageTypeOneToMany:
 			if len(r.ToRoleIds) == 0 {
 				err = errors.Errorf("to role is empty")
 				return
 			}
-			my.BatchCreateOneToManyMessage(message, r.ToRoleIds)
+			err = my.BatchCreateOneToManyMessage(message, r.ToRoleIds)
 			return
 		case ms.SysMessageTypeSystem:
 			my.CreateSystemMessage(message)
@@ -192,7 +192,7 @@ func (my MySql) CreateMessage(r *req.PushMessage) (err error) {
 }
 
 // BatchCreateOneToOneMessage one2one message
-func (my MySql) BatchCreateOneToOneMessage(message ms.SysMessage, toIds []uint) {
+func (my MySql) BatchCreateOneToOneMessage(message ms.SysMessage, toIds []uint) (err error) {
 	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "BatchCreateOneToOneMessage"))
 	defer span.End()
 	message.Type = ms.SysMessageTypeOneToOne
@@ -203,20 +203,26 @@ func (my MySql) BatchCreateOneToOneMessage(message ms.SysMessage, toIds []uint)
 			Carbon: carbon.Now().AddDays(30),
 		}
 	}
-	my.Tx.Create(&message)
+	err = my.Tx.Create(&message).Error
+	if err != nil {
+		return
+	}
 	// save ToUsers
 	for _, id := range toIds {
 		var log ms.SysMessageLog
 		log.MessageId = message.Id
 		log.ToUserId = id
-		my.Tx.Create(&log)
+		err = my.Tx.Create(&log).Error
+		if err != nil {
+			return
+		}
 	}
 
 	return
 }
 
 // BatchCreateOneToManyMessage one2many message
-func (my MySql) BatchCreateOneToManyMessage(message ms.SysMessage, toRoleIds []uint) {
+func (my MySql) BatchCreateOneToManyMessage(message ms.SysMessage, toRoleIds []uint) (err error) {
 	_, span := tracer.Start(my.Ctx, tracing.Name(tracing.Db, "BatchCreateOneToManyMessage"))
 	defer span.End()
 	message.Type = ms.SysMessageTypeOneToMany
@@ -231,8 +237,12 @@ func (my MySql) BatchCreateOneToManyMessage(message ms.SysMessage, toRoleIds []u
 	for _, id := range toRoleIds {
 		message.Id = 0
 		message.RoleId = id
-		my.Tx.Create(&message)
+		err = my.Tx.Create(&message).Error
+		if err != nil {
+			return
+		}
 	}
+	return
 }
 
 // CreateSystemMessage one2all message
@@ -21,6 +21,8 @@ type MysqlOptions struct {
 	cachePrefix string
 	enforcer    *casbin.Enforcer
 	fsmOps      []func(options *fsm.Options)
+	fsmNotify   bool
+	messageHub  *MessageHub
 }
 
 func WithMysqlDb(db *gorm.DB) func(*MysqlOptions) {
@@ -67,6 +69,22 @@ func WithMysqlFsmOps(ops ...func(options *fsm.Options)) func(*MysqlOptions) {
 	}
 }
 
+// WithMysqlFsmNotify fsm approvers and carbon copy recipients will receive one2one messages
+func WithMysqlFsmNotify(flag bool) func(*MysqlOptions) {
+	return func(options *MysqlOptions) {
+		getMysqlOptionsOrSetDefault(options).fsmNotify = flag
+	}
+}
+
+// WithMysqlMessageHub fsm notification will be pushed by message hub
+func WithMysqlMessageHub(hub *MessageHub) func(*MysqlOptions) {
+	return func(options *MysqlOptions) {
+		if hub != nil {
+			getMysqlOptionsOrSetDefault(options).messageHub = hub
+		}
+	}
+}
+
 func getMysqlOptionsOrSetDefault(options *MysqlOptions) *MysqlOptions {
 	if options == nil {
 		return &MysqlOptions{
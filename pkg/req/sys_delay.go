@@ -8,3 +8,18 @@ type DelayExportHistory struct {
 	End      *NullUint `json:"end" form:"end"`
 	resp.Page
 }
+
+type DelayTask struct {
+	Queue string `json:"queue" form:"queue"` // default queue if it empty
+	State string `json:"state" form:"state"` // pending/active/scheduled/retry/archived/completed, default pending
+	resp.Page
+}
+
+type DelayQueueName struct {
+	Queue string `json:"queue" form:"queue"`
+}
+
+type DelayTaskId struct {
+	Queue string `json:"queue" form:"queue"`
+	Uid   string `json:"uid" form:"uid"`
+}
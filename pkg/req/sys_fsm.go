@@ -1,6 +1,11 @@
 package req
 
-import "github.com/piupuer/go-helper/pkg/resp"
+import (
+	"github.com/golang-module/carbon/v2"
+	"github.com/piupuer/go-helper/pkg/resp"
+	"github.com/piupuer/go-helper/pkg/utils"
+	"strings"
+)
 
 type FsmCreateMachine struct {
 	Category                   NullUint         `json:"category"`
@@ -13,11 +18,22 @@ type FsmCreateMachine struct {
 }
 
 type FsmCreateEvent struct {
-	Name       string   `json:"name" form:"name"`
-	Edit       NullUint `json:"edit" form:"edit"`
-	EditFields string   `json:"editFields" form:"editFields"`
-	Roles      IdsStr   `json:"roles" form:"roles"`
-	Users      IdsStr   `json:"users" form:"users"`
+	Name        string   `json:"name" form:"name"`
+	Edit        NullUint `json:"edit" form:"edit"`
+	EditFields  string   `json:"editFields" form:"editFields"`
+	Roles       IdsStr   `json:"roles" form:"roles"`
+	Users       IdsStr   `json:"users" form:"users"`
+	Countersign NullUint `json:"countersign" form:"countersign"` // 0: any approver, 1: all approvers or quorum
+	Quorum      NullUint `json:"quorum" form:"quorum"`           // countersign required approvals(0: all approvers)
+	Guard       string   `json:"guard" form:"guard"`             // level take effect only if expression is true, example: amount > 10000
+	// approval SLA(hours)
+	Remind         NullUint `json:"remind" form:"remind"`                 // remind approvers after hours
+	Timeout        NullUint `json:"timeout" form:"timeout"`               // timeout after hours
+	TimeoutAction  NullUint `json:"timeoutAction" form:"timeoutAction"`   // 0: none, 1: escalate, 2: auto approve, 3: auto refuse
+	FallbackRoleId NullUint `json:"fallbackRoleId" form:"fallbackRoleId"` // escalate to fallback role
+	// carbon copy recipients
+	CcRoles IdsStr `json:"ccRoles" form:"ccRoles"`
+	CcUsers IdsStr `json:"ccUsers" form:"ccUsers"`
 }
 
 type FsmUpdateMachine struct {
@@ -41,6 +57,7 @@ type FsmCreateLog struct {
 	Uuid            string   `json:"uuid" form:"uuid"`
 	SubmitterRoleId uint     `json:"submitterRoleId" form:"submitterRoleId"`
 	SubmitterUserId uint     `json:"submitterUserId" form:"submitterUserId"`
+	SubmitterDetail string   `json:"submitterDetail" form:"submitterDetail"` // detail json str, level guard will use it
 	Remark          string   `json:"remark" form:"remark"`
 }
 
@@ -51,6 +68,27 @@ type FsmApproveLog struct {
 	ApprovalUserId  uint     `json:"approvalUserId"`
 	ApprovalOpinion string   `json:"approvalOpinion" form:"approvalOpinion"`
 	Approved        NullUint `json:"approved" form:"approved"`
+	SubmitterDetail string   `json:"submitterDetail" form:"submitterDetail"` // detail json str(keep last detail if it empty)
+	RejectLevel     *uint    `json:"rejectLevel" form:"rejectLevel"`         // refuse back to level(0: submitter resubmit, 1: L1...), previous level if it empty
+}
+
+type FsmBatchApproveLog struct {
+	Category        NullUint `json:"category" form:"category"`
+	Uuids           []string `json:"uuids" form:"uuids"`
+	ApprovalRoleId  uint     `json:"approvalRoleId"`
+	ApprovalUserId  uint     `json:"approvalUserId"`
+	ApprovalOpinion string   `json:"approvalOpinion" form:"approvalOpinion"`
+	Approved        NullUint `json:"approved" form:"approved"` // 1: approve, 2: refuse
+}
+
+type FsmAddSignLog struct {
+	Category        NullUint `json:"category" form:"category"`
+	Uuid            string   `json:"uuid" form:"uuid"`
+	ApprovalRoleId  uint     `json:"approvalRoleId"`
+	ApprovalUserId  uint     `json:"approvalUserId"`
+	ApprovalOpinion string   `json:"approvalOpinion" form:"approvalOpinion"`
+	Users           IdsStr   `json:"users" form:"users"`       // ad-hoc approver user ids
+	Position        NullUint `json:"position" form:"position"` // 1: before approver, 2: after approver
 }
 
 type FsmCheckEditLogDetailPermission struct {
@@ -59,7 +97,9 @@ type FsmCheckEditLogDetailPermission struct {
 	Approver       bool     `json:"approver"`
 	ApprovalRoleId uint     `json:"approvalRoleId"`
 	ApprovalUserId uint     `json:"approvalUserId"`
-	Fields         []string `json:"fields"`
+	Fields         []string `json:"fields"`    // changed fields, nested json path is split by dot, example: order.amount
+	Detail         string   `json:"detail"`    // new detail json str, changed paths compared with old detail will be checked
+	OldDetail      string   `json:"oldDetail"` // current detail json str(last submitter detail if it empty)
 }
 
 type FsmLogSubmitterDetail struct {
@@ -90,6 +130,46 @@ func (d *UpdateFsmLogSubmitterDetail) Parse() {
 	d.Vals = v
 }
 
+// Detail build current/new detail json str by current business detail and changed fields
+func (d UpdateFsmLogSubmitterDetail) Detail(current []resp.FsmLogSubmitterDetail) (oldDetail, newDetail string) {
+	m1 := make(map[string]interface{}, len(current))
+	m2 := make(map[string]interface{}, len(current))
+	for _, item := range current {
+		m1[item.Key] = item.Val
+		m2[item.Key] = item.Val
+	}
+	for _, field := range d.Fields {
+		m2[field.Key] = field.Val
+	}
+	oldDetail = utils.Struct2Json(m1)
+	newDetail = utils.Struct2Json(m2)
+	return
+}
+
+// DetailBy build new detail json str by submitter detail saved by the log and changed fields(nested key is split by dot)
+func (d UpdateFsmLogSubmitterDetail) DetailBy(detail string) (oldDetail, newDetail string) {
+	m := make(map[string]interface{})
+	if detail != "" {
+		utils.Json2Struct(detail, &m)
+	}
+	for _, field := range d.Fields {
+		keys := strings.Split(field.Key, ".")
+		item := m
+		for _, key := range keys[:len(keys)-1] {
+			next, ok := item[key].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				item[key] = next
+			}
+			item = next
+		}
+		item[keys[len(keys)-1]] = field.Val
+	}
+	oldDetail = detail
+	newDetail = utils.Struct2Json(m)
+	return
+}
+
 type FsmPermissionLog struct {
 	Category       NullUint `json:"category" form:"category"`
 	Uuid           string   `json:"uuid" form:"uuid"`
@@ -112,6 +192,38 @@ type FsmLog struct {
 	UserId   uint     `json:"userId"`
 }
 
+type FsmMachineVersionDiff struct {
+	Id   uint     `json:"id"`
+	From NullUint `json:"from" form:"from"` // from version
+	To   NullUint `json:"to" form:"to"`     // to version
+}
+
+type FsmMachineGraph struct {
+	Id   uint   `json:"id"`
+	Uuid string `json:"uuid" form:"uuid"` // highlight current progress of the log(optional)
+}
+
+type FsmAnalytics struct {
+	Category NullUint        `json:"category" form:"category"`
+	StartAt  carbon.DateTime `json:"startAt" form:"startAt"` // default 30 days before endAt
+	EndAt    carbon.DateTime `json:"endAt" form:"endAt"`     // default now
+}
+
+type FsmCreateDelegation struct {
+	FromUserId uint            `json:"fromUserId"`
+	FromRoleId uint            `json:"fromRoleId"`
+	ToUserId   NullUint        `json:"toUserId" form:"toUserId"`
+	StartAt    carbon.DateTime `json:"startAt" form:"startAt"`
+	EndAt      carbon.DateTime `json:"endAt" form:"endAt"`
+	Categories IdsStr          `json:"categories" form:"categories"` // limit machine categories(all categories if it empty)
+	Role       NullUint        `json:"role" form:"role"`             // 1: also delegate logs pending on delegator role(only delegator own approvals by default)
+}
+
+type FsmDelegation struct {
+	UserId uint `json:"userId"` // delegator or proxy user id
+	resp.Page
+}
+
 type FsmMachine struct {
 	Category         *NullUint `json:"category" form:"category"`
 	Name             string    `json:"name" form:"name"`
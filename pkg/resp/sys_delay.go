@@ -1,5 +1,7 @@
 package resp
 
+import "github.com/golang-module/carbon/v2"
+
 type DelayExportHistory struct {
 	Base
 	Uuid     string `json:"uuid"`
@@ -9,3 +11,73 @@ type DelayExportHistory struct {
 	End      uint   `json:"end"`
 	Url      string `json:"url"`
 }
+
+type DelayQueue struct {
+	Queue     string `json:"queue"`
+	Priority  int    `json:"priority"`
+	Size      int    `json:"size"` // all tasks exclude completed
+	Pending   int    `json:"pending"`
+	Active    int    `json:"active"`
+	Scheduled int    `json:"scheduled"`
+	Retry     int    `json:"retry"`
+	Archived  int    `json:"archived"`
+	Completed int    `json:"completed"`
+	Processed int    `json:"processed"` // processed today
+	Failed    int    `json:"failed"`    // failed today
+	Paused    bool   `json:"paused"`
+	Latency   int64  `json:"latency"` // seconds of the oldest pending task waiting
+}
+
+type DelayTask struct {
+	Uid           string          `json:"uid"`
+	Queue         string          `json:"queue"`
+	Name          string          `json:"name"`
+	Payload       string          `json:"payload"`
+	State         string          `json:"state"`
+	MaxRetry      int             `json:"maxRetry"`
+	Retried       int             `json:"retried"`
+	LastErr       string          `json:"lastErr"`
+	LastFailedAt  carbon.DateTime `json:"lastFailedAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+	NextProcessAt carbon.DateTime `json:"nextProcessAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+}
+
+type DelayPeriodTask struct {
+	Uid       string          `json:"uid"`
+	Queue     string          `json:"queue"`
+	Name      string          `json:"name"`
+	Payload   string          `json:"payload"`
+	Expr      string          `json:"expr"`
+	Next      carbon.DateTime `json:"next" swaggertype:"string" example:"2019-01-01 00:00:00"` // next run time
+	Processed int64           `json:"processed"`                                               // run times
+	MaxRetry  int             `json:"maxRetry"`
+	Timeout   int             `json:"timeout"`
+	Timezone  string          `json:"timezone"`
+	Jitter    int             `json:"jitter"`  // max random delay seconds
+	Misfire   string          `json:"misfire"` // missed runs policy: once/all/skip
+	EndAt     carbon.DateTime `json:"endAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+	MaxRuns   int64           `json:"maxRuns"`
+	Runs      int64           `json:"runs"` // scheduled times
+	Ended     bool            `json:"ended"`
+}
+
+type DelayCallback struct {
+	Url      string            `json:"url"`
+	Headers  map[string]string `json:"headers"`
+	Status   int               `json:"status"`   // last response status code
+	Resp     string            `json:"resp"`     // last response snippet
+	Err      string            `json:"err"`      // last error
+	Attempts int               `json:"attempts"` // attempts of last callback
+	At       carbon.DateTime   `json:"at" swaggertype:"string" example:"2019-01-01 00:00:00"`
+}
+
+type DelayTaskResult struct {
+	Uid         string          `json:"uid"`
+	Queue       string          `json:"queue"`
+	Name        string          `json:"name"`
+	State       string          `json:"state"` // pending/active/retrying/completed/failed
+	Retried     int             `json:"retried"`
+	MaxRetry    int             `json:"maxRetry"`
+	Result      string          `json:"result"`
+	LastErr     string          `json:"lastErr"`
+	CompletedAt carbon.DateTime `json:"completedAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+}
@@ -1,5 +1,7 @@
 package resp
 
+import "github.com/golang-module/carbon/v2"
+
 type FsmApprovalLog struct {
 	Uuid     string `json:"uuid"`
 	Category uint   `json:"category"`
@@ -7,6 +9,14 @@ type FsmApprovalLog struct {
 	Confirm  uint   `json:"confirm"`  // is waiting submitter confirm?
 	Resubmit uint   `json:"resubmit"` // is waiting submitter resubmit?
 	Cancel   uint   `json:"cancel"`   // is submitter canceled?
+	Waiting  uint   `json:"waiting"`  // is waiting other countersign/add sign approvers?
+	Withdraw uint   `json:"withdraw"` // is submitter withdrawn?
+}
+
+type FsmBatchApprovalLog struct {
+	FsmApprovalLog
+	Success uint   `json:"success"` // is approved successfully?
+	Error   string `json:"error"`   // failed reason
 }
 
 type FsmApprovingLog struct {
@@ -36,6 +46,49 @@ type FsmLogTrack struct {
 	Resubmit   uint   `json:"resubmit"`
 	Confirm    uint   `json:"confirm"`
 	Permission uint   `json:"permission"`
+	System     uint   `json:"system"`    // approved by system when timeout
+	Escalated  uint   `json:"escalated"` // escalated to fallback role when timeout
+	RejectTo   string `json:"rejectTo"`  // approver refused back to the level
+	AddSign    uint   `json:"addSign"`   // ad-hoc approval step(1: before approver, 2: after approver)
+	// approver, DelegatorUserId>0 means approved on behalf of delegator
+	ApprovalRoleId  uint `json:"approvalRoleId"`
+	ApprovalUserId  uint `json:"approvalUserId"`
+	DelegatorRoleId uint `json:"delegatorRoleId"`
+	DelegatorUserId uint `json:"delegatorUserId"`
+	// countersign level vote state
+	Countersign uint         `json:"countersign"`
+	Quorum      uint         `json:"quorum"`
+	Voted       uint         `json:"voted"`
+	Votes       []FsmLogVote `json:"votes"`
+	// submitter detail changed by the step
+	Diff []FsmDetailDiff `json:"diff"`
+}
+
+type FsmDetailDiff struct {
+	Path   string      `json:"path"`   // nested json path split by dot, example: order.amount
+	Action string      `json:"action"` // add/remove/update
+	Old    interface{} `json:"old"`
+	New    interface{} `json:"new"`
+}
+
+type FsmLogVote struct {
+	Time
+	ApprovalRoleId  uint   `json:"approvalRoleId"`
+	ApprovalUserId  uint   `json:"approvalUserId"`
+	Approved        uint   `json:"approved"`
+	Opinion         string `json:"opinion"`
+	DelegatorRoleId uint   `json:"delegatorRoleId"`
+	DelegatorUserId uint   `json:"delegatorUserId"`
+}
+
+type FsmDelegation struct {
+	Base
+	FromUserId uint            `json:"fromUserId"`
+	FromRoleId uint            `json:"fromRoleId"`
+	ToUserId   uint            `json:"toUserId"`
+	StartAt    carbon.DateTime `json:"startAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+	EndAt      carbon.DateTime `json:"endAt" swaggertype:"string" example:"2019-01-01 00:00:00"`
+	Categories string          `json:"categories"`
 }
 
 type FsmLogSubmitterDetail struct {
@@ -53,4 +106,53 @@ type FsmMachine struct {
 	SubmitterConfirm           uint   `json:"submitterConfirm"`
 	SubmitterConfirmEditFields string `json:"submitterConfirmEditFields"`
 	EventsJson                 string `json:"eventsJson"`
+	Version                    uint   `json:"version"`
+	HeadId                     uint   `json:"headId"`
+}
+
+type FsmMachineGraph struct {
+	Dot      string `json:"dot"`      // graphviz dot text
+	Mermaid  string `json:"mermaid"`  // mermaid state diagram text
+	Progress string `json:"progress"` // current progress of the log(uuid is not empty)
+}
+
+type FsmAnalytics struct {
+	Levels    []FsmLevelAnalytics  `json:"levels"`
+	Approvers []FsmApproverPending `json:"approvers"`
+	Completed []FsmDailyCompleted  `json:"completed"`
+}
+
+type FsmLevelAnalytics struct {
+	Level         uint    `json:"level"`
+	Name          string  `json:"name"`
+	Total         uint    `json:"total"`         // approved/refused steps
+	Refused       uint    `json:"refused"`       // refused steps
+	RejectionRate float64 `json:"rejectionRate"` // refused / total
+	AvgSeconds    float64 `json:"avgSeconds"`    // average time spent at the level
+	P95Seconds    float64 `json:"p95Seconds"`    // p95 time spent at the level
+}
+
+type FsmApproverPending struct {
+	UserId  uint `json:"userId"`
+	RoleId  uint `json:"roleId"`
+	Pending uint `json:"pending"`
+}
+
+type FsmDailyCompleted struct {
+	Date  string `json:"date"`
+	Count uint   `json:"count"`
+}
+
+type FsmMachineVersionDiff struct {
+	From    uint                   `json:"from"`
+	To      uint                   `json:"to"`
+	Machine map[string]interface{} `json:"machine"` // changed machine fields(new value)
+	Levels  []FsmLevelDiff         `json:"levels"`
+}
+
+type FsmLevelDiff struct {
+	Index  int                    `json:"index"`  // level index of to version(from version if it removed)
+	Name   string                 `json:"name"`   // level name
+	Action string                 `json:"action"` // add/remove/update
+	Fields map[string]interface{} `json:"fields"` // changed level fields(new value)
 }
@@ -16,12 +16,17 @@ func Transaction(options ...func(*TransactionOptions)) grpc.UnaryServerIntercept
 	}
 	return func(ctx context.Context, r interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 		tx := ops.dbNoTx.Begin()
+		// funcs registered by query.MySql.AfterCommit
+		afterCommit := make([]func(), 0)
 		c := context.WithValue(ctx, constant.MiddlewareTransactionTxCtxKey, tx)
+		c = context.WithValue(c, constant.MiddlewareTransactionAfterCommitCtxKey, &afterCommit)
 		resp, err := handler(c, r)
 		if err != nil {
 			tx.Rollback()
-		} else {
-			tx.Commit()
+		} else if tx.Commit().Error == nil {
+			for _, fun := range afterCommit {
+				fun()
+			}
 		}
 		return resp, err
 	}
@@ -7,3 +7,17 @@ func (rt Router) Delay() {
 	router1.GET("/export/list", v1.FindDelayExport(rt.ops.v1Ops...))
 	router1.DELETE("/export/delete/batch", v1.BatchDeleteDelayExportByIds(rt.ops.v1Ops...))
 }
+
+// DelayQueue delay queue inspection, v1.WithDelayQueue is required
+func (rt Router) DelayQueue() {
+	router1 := rt.Casbin("/delay/queue")
+	router1.GET("/list", v1.FindDelayQueue(rt.ops.v1Ops...))
+	router1.GET("/task/list", v1.FindDelayTask(rt.ops.v1Ops...))
+	router1.GET("/task/result", v1.GetDelayTaskResult(rt.ops.v1Ops...))
+	router1.GET("/task/callback", v1.GetDelayTaskCallback(rt.ops.v1Ops...))
+	router1.GET("/period/list", v1.FindDelayPeriodTask(rt.ops.v1Ops...))
+	router1.PATCH("/pause", v1.PauseDelayQueue(rt.ops.v1Ops...))
+	router1.PATCH("/resume", v1.ResumeDelayQueue(rt.ops.v1Ops...))
+	router1.PATCH("/task/run", v1.RunDelayTask(rt.ops.v1Ops...))
+	router1.DELETE("/task/delete", v1.DeleteDelayTask(rt.ops.v1Ops...))
+}
@@ -6,13 +6,22 @@ func (rt Router) Fsm() {
 	router1 := rt.Casbin("/fsm")
 	router2 := rt.CasbinAndIdempotence("/fsm")
 	router1.GET("/list", v1.FindFsm(rt.ops.v1Ops...))
+	router1.GET("/analytics", v1.GetFsmAnalytics(rt.ops.v1Ops...))
+	router1.GET("/graph/:id", v1.GetFsmGraph(rt.ops.v1Ops...))
 	router2.POST("/create", v1.CreateFsm(rt.ops.v1Ops...))
 	router1.PATCH("/update/:id", v1.UpdateFsmById(rt.ops.v1Ops...))
 	router1.DELETE("/delete/batch", v1.BatchDeleteFsmByIds(rt.ops.v1Ops...))
+	router1.GET("/version/list/:id", v1.FindFsmVersion(rt.ops.v1Ops...))
+	router1.GET("/version/diff/:id", v1.DiffFsmVersion(rt.ops.v1Ops...))
 	router1.GET("/log/approving/list", v1.FindFsmApprovingLog(rt.ops.v1Ops...))
 	router1.GET("/log/track", v1.FindFsmLogTrack(rt.ops.v1Ops...))
 	router1.GET("/log/submitter/detail", v1.GetFsmLogSubmitterDetail(rt.ops.v1Ops...))
 	router1.PATCH("/log/submitter/detail", v1.UpdateFsmLogSubmitterDetail(rt.ops.v1Ops...))
 	router1.PATCH("/log/approve", v1.FsmApproveLog(rt.ops.v1Ops...))
+	router1.PATCH("/log/approve/batch", v1.FsmBatchApproveLog(rt.ops.v1Ops...))
+	router1.PATCH("/log/sign", v1.FsmAddSignLog(rt.ops.v1Ops...))
 	router1.PATCH("/log/cancel", v1.FsmCancelLogByUuids(rt.ops.v1Ops...))
+	router1.GET("/delegation/list", v1.FindFsmDelegation(rt.ops.v1Ops...))
+	router2.POST("/delegation/create", v1.CreateFsmDelegation(rt.ops.v1Ops...))
+	router1.DELETE("/delegation/delete/batch", v1.BatchDeleteFsmDelegationByIds(rt.ops.v1Ops...))
 }